@@ -24,6 +24,16 @@ func NewRESTApiV1(opts RESTApiV1Options) *RESTApiV1 {
 
 	restAPI.router.HandleFunc(APIPath.Build(), restAPI.Build).Methods(http.MethodPost)
 	restAPI.router.HandleFunc(APIPath.Status(), restAPI.Status).Methods(http.MethodGet)
+	restAPI.router.HandleFunc(APIPath.Builds(), restAPI.CancelBuild).Methods(http.MethodDelete)
+	restAPI.router.HandleFunc(APIPath.BuildArtifact(), restAPI.BuildArtifact).Methods(http.MethodGet, http.MethodHead)
+	restAPI.router.HandleFunc(APIPath.Schedules(), restAPI.CreateSchedule).Methods(http.MethodPost)
+	restAPI.router.HandleFunc(APIPath.Schedules(), restAPI.ListSchedules).Methods(http.MethodGet)
+	restAPI.router.HandleFunc(APIPath.Schedule(), restAPI.GetSchedule).Methods(http.MethodGet)
+	restAPI.router.HandleFunc(APIPath.Schedule(), restAPI.DeleteSchedule).Methods(http.MethodDelete)
+	restAPI.router.HandleFunc(APIPath.SchedulePause(), restAPI.PauseSchedule).Methods(http.MethodPost)
+	restAPI.router.HandleFunc(APIPath.ScheduleResume(), restAPI.ResumeSchedule).Methods(http.MethodPost)
+	restAPI.router.HandleFunc(APIPath.Lint(), restAPI.Lint).Methods(http.MethodPost)
+	restAPI.router.HandleFunc(APIPath.Health(), restAPI.Health).Methods(http.MethodGet)
 
 	return restAPI
 }
@@ -33,7 +43,7 @@ func (a *RESTApiV1) Serve(addr, originAllowed string) error {
 
 	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization", "X-CSRF-Token"})
 	originsOk := handlers.AllowedOrigins([]string{originAllowed})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
 
 	a.logger.Info(fmt.Sprintf("serving on %s", addr))
 
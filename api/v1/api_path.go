@@ -13,3 +13,35 @@ func (e *serviceEndpointPath) Status() string {
 func (e *serviceEndpointPath) Build() string {
 	return endpointPrefix + "/build"
 }
+
+func (e *serviceEndpointPath) Builds() string {
+	return endpointPrefix + "/builds/{image_id}"
+}
+
+func (e *serviceEndpointPath) BuildArtifact() string {
+	return endpointPrefix + "/builds/{image_id}/artifact"
+}
+
+func (e *serviceEndpointPath) Schedules() string {
+	return endpointPrefix + "/schedules"
+}
+
+func (e *serviceEndpointPath) Schedule() string {
+	return endpointPrefix + "/schedules/{schedule_id}"
+}
+
+func (e *serviceEndpointPath) SchedulePause() string {
+	return endpointPrefix + "/schedules/{schedule_id}/pause"
+}
+
+func (e *serviceEndpointPath) ScheduleResume() string {
+	return endpointPrefix + "/schedules/{schedule_id}/resume"
+}
+
+func (e *serviceEndpointPath) Lint() string {
+	return endpointPrefix + "/lint"
+}
+
+func (e *serviceEndpointPath) Health() string {
+	return endpointPrefix + "/health"
+}
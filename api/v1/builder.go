@@ -2,6 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 
 	"github.com/celestiaorg/dockwiz/pkg/builder"
@@ -9,24 +13,28 @@ import (
 	"go.uber.org/zap"
 )
 
-// Build is the handler for the /api/v1/build endpoint
+const (
+	multipartOptions  = "options"
+	multipartContext  = "context"
+	multipartOverhead = 1 << 20
+)
+
+// Build is the handler for the /api/v1/build endpoint. It accepts either the
+// JSON build options, or a multipart form uploading the build context.
 func (a *RESTApiV1) Build(resp http.ResponseWriter, req *http.Request) {
-	var bOpts builder.BuilderOptions
-	if err := json.NewDecoder(req.Body).Decode(&bOpts); err != nil {
-		sendJSONError(resp,
-			Message{
-				Type:    MessageTypeError,
-				Slug:    SlugJSONDecodeFailed,
-				Title:   "JSON decode failed",
-				Message: err.Error(),
-			},
-			http.StatusBadRequest)
-		a.loggerNoStack.Error("JSON decode failed", zap.Error(err))
+	bOpts, ok := a.decodeBuildOptions(resp, req)
+	if !ok {
 		return
 	}
 
 	res, err := a.builder.AddToBuildQueue(bOpts)
 	if err != nil {
+		var lintErr *builder.LintError
+		if errors.As(err, &lintErr) {
+			sendJSONError(resp, newLintResponse(lintErr.Violations), http.StatusUnprocessableEntity)
+			return
+		}
+
 		sendJSONError(resp,
 			Message{
 				Type:    MessageTypeError,
@@ -81,3 +89,196 @@ func (a *RESTApiV1) Status(resp http.ResponseWriter, req *http.Request) {
 		a.loggerNoStack.Error("sending JSON response", zap.Error(err))
 	}
 }
+
+// CancelBuild is the handler for the DELETE /api/v1/builds/{image_id} endpoint
+func (a *RESTApiV1) CancelBuild(resp http.ResponseWriter, req *http.Request) {
+	imageId := mux.Vars(req)["image_id"]
+
+	if err := a.builder.Cancel(imageId); err != nil {
+		switch err {
+		case builder.ErrBuildNotFound:
+			sendJSONError(resp,
+				Message{
+					Type:    MessageTypeWarning,
+					Slug:    SlugBuildStatusNotFound,
+					Title:   "build status not found",
+					Message: err.Error(),
+				},
+				http.StatusNotFound)
+			return
+		case builder.ErrBuildNotCancellable:
+			sendJSONError(resp,
+				Message{
+					Type:    MessageTypeWarning,
+					Slug:    SlugBuildNotCancellable,
+					Title:   "build cannot be cancelled",
+					Message: err.Error(),
+				},
+				http.StatusConflict)
+			return
+		}
+
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugCancelBuildFailed,
+				Title:   "cancelling build failed",
+				Message: err.Error(),
+			},
+			http.StatusInternalServerError)
+		a.loggerNoStack.Error("cancelling build failed", zap.Error(err))
+		return
+	}
+
+	// Return the status to make it visible the partial logs are kept
+	a.Status(resp, req)
+}
+
+// BuildArtifact is the handler for the GET /api/v1/builds/{image_id}/artifact
+// endpoint, which streams the tarball of a build which was not pushed
+func (a *RESTApiV1) BuildArtifact(resp http.ResponseWriter, req *http.Request) {
+	imageId := mux.Vars(req)["image_id"]
+
+	f, status, err := a.builder.Artifact(imageId)
+	if err != nil {
+		switch err {
+		case builder.ErrBuildNotFound:
+			sendJSONError(resp,
+				Message{
+					Type:    MessageTypeWarning,
+					Slug:    SlugBuildStatusNotFound,
+					Title:   "build status not found",
+					Message: err.Error(),
+				},
+				http.StatusNotFound)
+			return
+		case builder.ErrArtifactNotFound:
+			sendJSONError(resp,
+				Message{
+					Type:    MessageTypeWarning,
+					Slug:    SlugArtifactNotFound,
+					Title:   "build artifact not found",
+					Message: err.Error(),
+				},
+				http.StatusNotFound)
+			return
+		}
+
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugGetArtifactFailed,
+				Title:   "getting build artifact failed",
+				Message: err.Error(),
+			},
+			http.StatusInternalServerError)
+		a.loggerNoStack.Error("getting build artifact failed", zap.Error(err))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugGetArtifactFailed,
+				Title:   "getting build artifact failed",
+				Message: err.Error(),
+			},
+			http.StatusInternalServerError)
+		a.loggerNoStack.Error("getting build artifact failed", zap.Error(err))
+		return
+	}
+
+	fileName := fmt.Sprintf("%s-%s.tar", imageId, status.Artifact.Format)
+	resp.Header().Set("Content-Type", "application/x-tar")
+	resp.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	// ServeContent handles the range requests of resumed downloads
+	http.ServeContent(resp, req, fileName, info.ModTime(), f)
+}
+
+// decodeBuildOptions reads the build options of a request, either JSON or a
+// multipart form uploading the build context. It sends the error response
+// when they cannot be read.
+func (a *RESTApiV1) decodeBuildOptions(resp http.ResponseWriter, req *http.Request) (builder.BuilderOptions, bool) {
+	var bOpts builder.BuilderOptions
+	if isMultipart(req) {
+		var err error
+		bOpts, err = parseMultipartBuild(resp, req)
+		if err != nil {
+			sendJSONError(resp,
+				Message{
+					Type:    MessageTypeError,
+					Slug:    SlugContextUploadFailed,
+					Title:   "build context upload failed",
+					Message: err.Error(),
+				},
+				http.StatusBadRequest)
+			a.loggerNoStack.Error("build context upload failed", zap.Error(err))
+			return bOpts, false
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&bOpts); err != nil {
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugJSONDecodeFailed,
+				Title:   "JSON decode failed",
+				Message: err.Error(),
+			},
+			http.StatusBadRequest)
+		a.loggerNoStack.Error("JSON decode failed", zap.Error(err))
+		return bOpts, false
+	}
+	return bOpts, true
+}
+
+func isMultipart(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// parseMultipartBuild reads a build request with an uploaded build context.
+// The `options` part holds the JSON build options and the `context` part the
+// tar.gz archive of the build context.
+func parseMultipartBuild(resp http.ResponseWriter, req *http.Request) (builder.BuilderOptions, error) {
+	var bOpts builder.BuilderOptions
+
+	// Leave some room for the options on top of the archive
+	req.Body = http.MaxBytesReader(resp, req.Body, builder.MaxContextArchiveSize+multipartOverhead)
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return bOpts, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bOpts, err
+		}
+
+		switch part.FormName() {
+		case multipartOptions:
+			if err := json.NewDecoder(part).Decode(&bOpts); err != nil {
+				return bOpts, fmt.Errorf("decoding build options: %w", err)
+			}
+		case multipartContext:
+			archive, err := io.ReadAll(io.LimitReader(part, builder.MaxContextArchiveSize+1))
+			if err != nil {
+				return bOpts, fmt.Errorf("reading build context: %w", err)
+			}
+			if len(archive) > builder.MaxContextArchiveSize {
+				return bOpts, builder.ErrContextArchiveTooLarge
+			}
+			bOpts.ContextArchive = archive
+		}
+	}
+
+	if bOpts.ContextArchive == nil {
+		return bOpts, fmt.Errorf("the %q part is required", multipartContext)
+	}
+	return bOpts, nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultipartBuild(t *testing.T) {
+	archive := []byte{0x1f, 0x8b, 0x08, 0x00}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField(multipartOptions, `{"image": {"name": "uploaded"}}`))
+	fw, err := mw.CreateFormFile(multipartContext, "context.tar.gz")
+	require.NoError(t, err)
+	_, err = fw.Write(archive)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest("POST", "/api/v1/build", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	require.True(t, isMultipart(req), "The request should be detected as multipart")
+
+	bOpts, err := parseMultipartBuild(httptest.NewRecorder(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "uploaded", bOpts.Image.Name, "Options should be decoded")
+	assert.Equal(t, archive, bOpts.ContextArchive, "The archive should be read")
+
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField(multipartOptions, `{}`))
+	require.NoError(t, mw.Close())
+	req = httptest.NewRequest("POST", "/api/v1/build", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	_, err = parseMultipartBuild(httptest.NewRecorder(), req)
+	assert.Error(t, err, "The context part should be required")
+
+	req = httptest.NewRequest("POST", "/api/v1/build", nil)
+	req.Header.Set("Content-Type", "application/json")
+	assert.False(t, isMultipart(req))
+}
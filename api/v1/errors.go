@@ -10,7 +10,16 @@ const (
 	SlugBuildFailed          = "build-failed"
 	SlugGetBuildStatusFailed = "get-build-status-failed"
 	SlugBuildStatusNotFound  = "build-status-not-found"
+	SlugCancelBuildFailed    = "cancel-build-failed"
+	SlugBuildNotCancellable  = "build-not-cancellable"
 	SlugJSONDecodeFailed     = "json-decode-failed"
+	SlugContextUploadFailed  = "context-upload-failed"
+	SlugArtifactNotFound     = "artifact-not-found"
+	SlugGetArtifactFailed    = "get-artifact-failed"
+	SlugLintFailed           = "lint-failed"
+	SlugScheduleNotFound     = "schedule-not-found"
+	SlugInvalidSchedule      = "invalid-schedule"
+	SlugScheduleFailed       = "schedule-failed"
 	SlugTypeError            = "type-error"
 )
 
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/celestiaorg/dockwiz/pkg/builder"
+	"go.uber.org/zap"
+)
+
+// Health is the handler for the /api/v1/health endpoint. It answers 503
+// Service Unavailable when the builder cannot reach redis.
+func (a *RESTApiV1) Health(resp http.ResponseWriter, _ *http.Request) {
+	health := a.builder.Health()
+	if health.Status == builder.HealthUnavailable {
+		sendJSONError(resp, health, http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := sendJSON(resp, health); err != nil {
+		a.loggerNoStack.Error("sending JSON response", zap.Error(err))
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+	"go.uber.org/zap"
+)
+
+// LintResponse lists the lint violations of a Dockerfile
+type LintResponse struct {
+	Valid    bool      `json:"valid"` // false if there is any error
+	Messages []Message `json:"messages"`
+}
+
+func newLintResponse(violations []lint.Violation) LintResponse {
+	res := LintResponse{
+		Valid:    !lint.HasErrors(violations),
+		Messages: []Message{},
+	}
+	for _, v := range violations {
+		msg := Message{
+			Type:    string(v.Severity),
+			Slug:    v.Rule,
+			Title:   v.Title,
+			Message: v.Message,
+		}
+		if v.Line > 0 {
+			msg.Message = fmt.Sprintf("line %d: %s", v.Line, v.Message)
+		}
+		res.Messages = append(res.Messages, msg)
+	}
+	return res
+}
+
+// Lint is the handler for the /api/v1/lint endpoint. It takes the same
+// options as the build endpoint and lints their Dockerfile without building.
+func (a *RESTApiV1) Lint(resp http.ResponseWriter, req *http.Request) {
+	bOpts, ok := a.decodeBuildOptions(resp, req)
+	if !ok {
+		return
+	}
+
+	violations, err := a.builder.Lint(req.Context(), bOpts)
+	if err != nil {
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugLintFailed,
+				Title:   "lint failed",
+				Message: err.Error(),
+			},
+			http.StatusBadRequest)
+		a.loggerNoStack.Error("lint failed", zap.Error(err))
+		return
+	}
+
+	if err := sendJSON(resp, newLintResponse(violations)); err != nil {
+		a.loggerNoStack.Error("sending JSON response", zap.Error(err))
+	}
+}
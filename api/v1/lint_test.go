@@ -0,0 +1,24 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLintResponse(t *testing.T) {
+	res := newLintResponse(nil)
+	assert.True(t, res.Valid)
+	assert.NotNil(t, res.Messages, "Messages should be an empty list, not null")
+
+	res = newLintResponse([]lint.Violation{
+		{Rule: lint.RuleUnknownArg, Title: "unused build arg", Severity: lint.SeverityWarning, Message: "build arg X is ignored"},
+		{Rule: lint.RuleSyntax, Title: "syntax error", Severity: lint.SeverityError, Line: 2, Message: "unknown instruction"},
+	})
+	assert.False(t, res.Valid)
+	assert.Equal(t, []Message{
+		{Type: MessageTypeWarning, Slug: lint.RuleUnknownArg, Title: "unused build arg", Message: "build arg X is ignored"},
+		{Type: MessageTypeError, Slug: lint.RuleSyntax, Title: "syntax error", Message: "line 2: unknown instruction"},
+	}, res.Messages)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/celestiaorg/dockwiz/pkg/builder"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// CreateSchedule is the handler for the POST /api/v1/schedules endpoint. It
+// takes the cron expression of the schedule and the build options to queue.
+func (a *RESTApiV1) CreateSchedule(resp http.ResponseWriter, req *http.Request) {
+	var s builder.Schedule
+	if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugJSONDecodeFailed,
+				Title:   "JSON decode failed",
+				Message: err.Error(),
+			},
+			http.StatusBadRequest)
+		a.loggerNoStack.Error("JSON decode failed", zap.Error(err))
+		return
+	}
+
+	s, err := a.builder.CreateSchedule(s)
+	if err != nil {
+		a.sendScheduleError(resp, "creating schedule failed", err)
+		return
+	}
+	a.sendSchedule(resp, s)
+}
+
+// ListSchedules is the handler for the GET /api/v1/schedules endpoint
+func (a *RESTApiV1) ListSchedules(resp http.ResponseWriter, req *http.Request) {
+	schedules, err := a.builder.ListSchedules()
+	if err != nil {
+		a.sendScheduleError(resp, "listing schedules failed", err)
+		return
+	}
+
+	for i := range schedules {
+		schedules[i] = publicSchedule(schedules[i])
+	}
+	if err := sendJSON(resp, schedules); err != nil {
+		a.loggerNoStack.Error("sending JSON response", zap.Error(err))
+	}
+}
+
+// GetSchedule is the handler for the GET /api/v1/schedules/{schedule_id}
+// endpoint
+func (a *RESTApiV1) GetSchedule(resp http.ResponseWriter, req *http.Request) {
+	s, err := a.builder.GetSchedule(mux.Vars(req)["schedule_id"])
+	if err != nil {
+		a.sendScheduleError(resp, "getting schedule failed", err)
+		return
+	}
+	a.sendSchedule(resp, s)
+}
+
+// PauseSchedule is the handler for the POST
+// /api/v1/schedules/{schedule_id}/pause endpoint
+func (a *RESTApiV1) PauseSchedule(resp http.ResponseWriter, req *http.Request) {
+	s, err := a.builder.PauseSchedule(mux.Vars(req)["schedule_id"])
+	if err != nil {
+		a.sendScheduleError(resp, "pausing schedule failed", err)
+		return
+	}
+	a.sendSchedule(resp, s)
+}
+
+// ResumeSchedule is the handler for the POST
+// /api/v1/schedules/{schedule_id}/resume endpoint
+func (a *RESTApiV1) ResumeSchedule(resp http.ResponseWriter, req *http.Request) {
+	s, err := a.builder.ResumeSchedule(mux.Vars(req)["schedule_id"])
+	if err != nil {
+		a.sendScheduleError(resp, "resuming schedule failed", err)
+		return
+	}
+	a.sendSchedule(resp, s)
+}
+
+// DeleteSchedule is the handler for the DELETE
+// /api/v1/schedules/{schedule_id} endpoint
+func (a *RESTApiV1) DeleteSchedule(resp http.ResponseWriter, req *http.Request) {
+	if err := a.builder.DeleteSchedule(mux.Vars(req)["schedule_id"]); err != nil {
+		a.sendScheduleError(resp, "deleting schedule failed", err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// publicSchedule returns a schedule without the encrypted secrets of its
+// build, which only the builder needs; its secret names are returned instead
+func publicSchedule(s builder.Schedule) builder.Schedule {
+	s.Build.EncryptedSecrets = nil
+	return s
+}
+
+func (a *RESTApiV1) sendSchedule(resp http.ResponseWriter, s builder.Schedule) {
+	if err := sendJSON(resp, publicSchedule(s)); err != nil {
+		a.loggerNoStack.Error("sending JSON response", zap.Error(err))
+	}
+}
+
+func (a *RESTApiV1) sendScheduleError(resp http.ResponseWriter, title string, err error) {
+	switch {
+	case errors.Is(err, builder.ErrScheduleNotFound):
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeWarning,
+				Slug:    SlugScheduleNotFound,
+				Title:   "schedule not found",
+				Message: err.Error(),
+			},
+			http.StatusNotFound)
+	case errors.Is(err, builder.ErrInvalidSchedule):
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugInvalidSchedule,
+				Title:   title,
+				Message: err.Error(),
+			},
+			http.StatusBadRequest)
+	default:
+		sendJSONError(resp,
+			Message{
+				Type:    MessageTypeError,
+				Slug:    SlugScheduleFailed,
+				Title:   title,
+				Message: err.Error(),
+			},
+			http.StatusInternalServerError)
+		a.loggerNoStack.Error(title, zap.Error(err))
+	}
+}
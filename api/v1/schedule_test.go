@@ -0,0 +1,24 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicSchedule(t *testing.T) {
+	s := builder.Schedule{
+		ID:          "nightly",
+		Build:       builder.BuilderOptions{EncryptedSecrets: []byte("sealed")},
+		SecretNames: []string{"TOKEN"},
+	}
+
+	data, err := json.Marshal(publicSchedule(s))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "encrypted_secrets", "Encrypted secrets should not be returned")
+	assert.Contains(t, string(data), `"secret_names":["TOKEN"]`)
+	assert.NotEmpty(t, s.Build.EncryptedSecrets, "The schedule itself should not be modified")
+}
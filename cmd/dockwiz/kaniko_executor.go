@@ -0,0 +1,24 @@
+package dockwiz
+
+import (
+	"os"
+
+	"github.com/celestiaorg/dockwiz/pkg/builder"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(kanikoExecutorCmd)
+}
+
+// kanikoExecutorCmd is started by the builder for every step of a build when
+// the subprocess executor is used; it is not meant to be run by users
+var kanikoExecutorCmd = &cobra.Command{
+	Use:    builder.KanikoProcessCommand + " <step>",
+	Short:  "runs a single Kaniko step of a build in an isolated process",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return builder.RunKanikoProcess(args[0], os.Stdin)
+	},
+}
@@ -1,8 +1,14 @@
 package dockwiz
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	api "github.com/celestiaorg/dockwiz/api/v1"
 	"github.com/celestiaorg/dockwiz/pkg/builder"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
 	"github.com/go-redis/redis"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -16,6 +22,29 @@ const (
 	redisAddr     = "redis-addr"
 	redisPassword = "redis-password"
 	redisDB       = "redis-db"
+
+	flagSecretsKey      = "secrets-key"
+	envSecretsKey       = "DOCKWIZ_SECRETS_KEY"
+	flagCredentialsFile = "credentials-file"
+
+	flagExecutor          = "executor"
+	flagMaxBuildTimeout   = "max-build-timeout"
+	flagLocalContextRoot  = "local-context-root"
+	flagContextURLHosts   = "context-url-allowed-hosts"
+	flagCacheRepo         = "cache-repo"
+	flagCacheTTL          = "cache-ttl"
+	flagArtifactsDir      = "artifacts-dir"
+	flagDedupWindow       = "dedup-window"
+	flagRetryMaxAttempts  = "retry-max-attempts"
+	flagRetryBackoff      = "retry-backoff"
+	flagMaxDiskUsage      = "max-disk-usage"
+	flagVisibilityTimeout = "visibility-timeout"
+	flagStarvationLimit   = "starvation-limit"
+	flagOwnerMaxBuilds    = "owner-max-builds"
+	flagOwnerLimits       = "owner-limits"
+
+	executorInProcess  = "in-process"
+	executorSubprocess = "subprocess"
 )
 
 var flagsServe struct {
@@ -27,6 +56,25 @@ var flagsServe struct {
 	redisAddr     string
 	redisPassword string
 	redisDB       int
+
+	secretsKey      string
+	credentialsFile string
+
+	executor          string
+	maxBuildTimeout   time.Duration
+	localContextRoot  string
+	contextURLHosts   []string
+	cacheRepo         string
+	cacheTTL          time.Duration
+	artifactsDir      string
+	dedupWindow       time.Duration
+	retryMaxAttempts  int
+	retryBackoff      time.Duration
+	maxDiskUsage      float64
+	visibilityTimeout time.Duration
+	starvationLimit   int
+	ownerMaxBuilds    int
+	ownerLimits       map[string]int
 }
 
 func init() {
@@ -41,6 +89,25 @@ func init() {
 	serveCmd.PersistentFlags().StringVar(&flagsServe.redisAddr, redisAddr, "localhost:6379", "redis address")
 	serveCmd.PersistentFlags().StringVar(&flagsServe.redisPassword, redisPassword, "", "redis password")
 	serveCmd.PersistentFlags().IntVar(&flagsServe.redisDB, redisDB, 0, "redis database")
+
+	serveCmd.PersistentFlags().StringVar(&flagsServe.secretsKey, flagSecretsKey, "", "base64 encoded 32 bytes key to encrypt build secrets in the queue (env: "+envSecretsKey+")")
+	serveCmd.PersistentFlags().StringVar(&flagsServe.credentialsFile, flagCredentialsFile, "", "path to a JSON file with the credentials builds can refer to by name")
+
+	serveCmd.PersistentFlags().StringVar(&flagsServe.executor, flagExecutor, executorInProcess, "how builds are run: "+executorSubprocess+" or "+executorInProcess+" (in-process builds cannot be interrupted mid-step on cancel or timeout)")
+	serveCmd.PersistentFlags().DurationVar(&flagsServe.maxBuildTimeout, flagMaxBuildTimeout, time.Hour, "longest time a build can run, also used for builds without a timeout")
+	serveCmd.PersistentFlags().StringVar(&flagsServe.localContextRoot, flagLocalContextRoot, "", "directory whose subdirectories builds can use as their context (only for trusted deployments, disabled if empty)")
+	serveCmd.PersistentFlags().StringSliceVar(&flagsServe.contextURLHosts, flagContextURLHosts, nil, "hosts the context archives of builds can be downloaded from (any public host if empty)")
+	serveCmd.PersistentFlags().StringVar(&flagsServe.cacheRepo, flagCacheRepo, "", "repository to cache the layers in, for builds without their own (defaults to the repository of the first destination)")
+	serveCmd.PersistentFlags().DurationVar(&flagsServe.cacheTTL, flagCacheTTL, 14*24*time.Hour, "how long cached layers are used")
+	serveCmd.PersistentFlags().StringVar(&flagsServe.artifactsDir, flagArtifactsDir, "/var/lib/dockwiz/artifacts", "directory the images of builds with a tar or oci output are saved in (must be shared by the instances sharing the redis)")
+	serveCmd.PersistentFlags().DurationVar(&flagsServe.dedupWindow, flagDedupWindow, 0, "how long after it succeeded a build is reused by identical build requests (0 disables deduplication)")
+	serveCmd.PersistentFlags().IntVar(&flagsServe.retryMaxAttempts, flagRetryMaxAttempts, 3, "attempts of the builds failing with transient errors, for builds without their own retry policy (1 disables retries)")
+	serveCmd.PersistentFlags().DurationVar(&flagsServe.retryBackoff, flagRetryBackoff, 30*time.Second, "delay before the first retry of a build, doubled after each retry")
+	serveCmd.PersistentFlags().Float64Var(&flagsServe.maxDiskUsage, flagMaxDiskUsage, 90, "percent of the workspace filesystem in use above which no build starts (0 disables the ceiling)")
+	serveCmd.PersistentFlags().DurationVar(&flagsServe.visibilityTimeout, flagVisibilityTimeout, 2*time.Minute, "how long a build stays reserved by a worker which stopped responding before it is queued again (0 disables the reliable queue)")
+	serveCmd.PersistentFlags().IntVar(&flagsServe.starvationLimit, flagStarvationLimit, redisqueue.DefaultStarvationLimit, "builds of higher priorities run in a row while a lower priority build waits, before it runs")
+	serveCmd.PersistentFlags().IntVar(&flagsServe.ownerMaxBuilds, flagOwnerMaxBuilds, 0, "builds of an owner running at once across the instances, for owners without their own limit (0 disables the limit, requires a visibility timeout)")
+	serveCmd.PersistentFlags().StringToIntVar(&flagsServe.ownerLimits, flagOwnerLimits, nil, "builds of specific owners running at once, e.g. team-a=5,github.com/org/repo=2")
 }
 
 var serveCmd = &cobra.Command{
@@ -76,10 +143,62 @@ var serveCmd = &cobra.Command{
 			return nil
 		}
 
+		var builderOpts []builder.Option
+
+		secretsKey := flagsServe.secretsKey
+		if secretsKey == "" {
+			secretsKey = os.Getenv(envSecretsKey)
+		}
+		if secretsKey != "" {
+			key, err := builder.ParseSecretsKey(secretsKey)
+			if err != nil {
+				return err
+			}
+			builderOpts = append(builderOpts, builder.WithSecretsKey(key))
+		}
+
+		if flagsServe.credentialsFile != "" {
+			store, err := credentials.LoadFile(flagsServe.credentialsFile)
+			if err != nil {
+				return err
+			}
+			builderOpts = append(builderOpts, builder.WithCredentials(store))
+		}
+
+		switch flagsServe.executor {
+		case executorInProcess:
+		case executorSubprocess:
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("getting the executable path: %w", err)
+			}
+			builderOpts = append(builderOpts, builder.WithSubprocessExecutor(executable))
+		default:
+			return fmt.Errorf("unknown executor %q", flagsServe.executor)
+		}
+		builderOpts = append(builderOpts,
+			builder.WithMaxBuildTimeout(flagsServe.maxBuildTimeout),
+			builder.WithCacheRepo(flagsServe.cacheRepo),
+			builder.WithCacheTTL(flagsServe.cacheTTL),
+			builder.WithArtifactsDir(flagsServe.artifactsDir),
+			builder.WithDedupWindow(flagsServe.dedupWindow),
+			builder.WithRetryPolicy(flagsServe.retryMaxAttempts, flagsServe.retryBackoff),
+			builder.WithMaxDiskUsage(flagsServe.maxDiskUsage),
+			builder.WithVisibilityTimeout(flagsServe.visibilityTimeout),
+			builder.WithStarvationLimit(flagsServe.starvationLimit),
+			builder.WithOwnerLimits(flagsServe.ownerMaxBuilds, flagsServe.ownerLimits),
+		)
+		if len(flagsServe.contextURLHosts) > 0 {
+			builderOpts = append(builderOpts, builder.WithContextURLAllowedHosts(flagsServe.contextURLHosts...))
+		}
+		if flagsServe.localContextRoot != "" {
+			builderOpts = append(builderOpts, builder.WithLocalContextRoot(flagsServe.localContextRoot))
+		}
+
 		opts := api.RESTApiV1Options{
 			ProductionMode: flagsServe.productionMode,
 			Logger:         logger,
-			Builder:        builder.NewBuilder(rdc, logger),
+			Builder:        builder.NewBuilder(rdc, logger, builderOpts...),
 		}
 
 		opts.Builder.Start()
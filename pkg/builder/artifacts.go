@@ -0,0 +1,251 @@
+package builder
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"go.uber.org/zap"
+)
+
+const (
+	// OutputRegistry pushes the image to its destinations
+	OutputRegistry = "registry"
+	// OutputTar saves the image as a tarball loadable with `docker load`
+	OutputTar = "tar"
+	// OutputOCI saves the image as a tarball of an OCI image layout, which
+	// also holds multi-platform images
+	OutputOCI = "oci"
+
+	// artifactSweepInterval is how often the expired artifacts are deleted
+	artifactSweepInterval = 10 * time.Minute
+)
+
+// ArtifactResult describes the tarball of a build which was not pushed
+type ArtifactResult struct {
+	Format    string    `json:"format"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func validateOutput(output string, platforms []string) error {
+	switch output {
+	case "", OutputRegistry, OutputOCI:
+		return nil
+	case OutputTar:
+		if len(platforms) > 1 {
+			return fmt.Errorf("multi-platform images cannot be saved as %q, use %q", OutputTar, OutputOCI)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output %q, use one of %s, %s and %s", output, OutputRegistry, OutputTar, OutputOCI)
+}
+
+// pushesToRegistry tells if the image of a build is pushed, or saved as an
+// artifact
+func (b BuilderOptions) pushesToRegistry() bool {
+	return b.Output == "" || b.Output == OutputRegistry
+}
+
+// artifactPath returns the path of the artifact of a build. Image names are
+// user supplied, so they are hashed rather than used as file names.
+func (b *Builder) artifactPath(imageName string) string {
+	sum := sha256.Sum256([]byte(imageName))
+	return path.Join(b.artifactsDir, hex.EncodeToString(sum[:])+".tar")
+}
+
+// Artifact opens the tarball of a build. The caller must close it.
+func (b *Builder) Artifact(imageName string) (*os.File, BuildStatusData, error) {
+	bd, err := b.GetBuildStatus(imageName)
+	if err != nil {
+		return nil, BuildStatusData{}, err
+	}
+	if bd.Artifact == nil {
+		return nil, bd, ErrArtifactNotFound
+	}
+
+	f, err := os.Open(b.artifactPath(imageName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, bd, ErrArtifactNotFound
+		}
+		return nil, bd, err
+	}
+	return f, bd, nil
+}
+
+// saveArtifact writes the image, or the image index of multi-platform
+// builds, as a tarball at dst
+func saveArtifact(dst string, bOpts BuilderOptions, workspace string, image v1.Image, index v1.ImageIndex) (*ArtifactResult, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+
+	// The artifact is written aside and renamed, so it is never served
+	// while incomplete
+	tmp := dst + ".tmp"
+	defer os.Remove(tmp)
+
+	var err error
+	switch bOpts.Output {
+	case OutputTar:
+		err = writeDockerArchive(tmp, bOpts.Image, image)
+	case OutputOCI:
+		err = writeOCIArchive(tmp, path.Join(workspace, "oci-layout"), image, index)
+	default:
+		err = fmt.Errorf("unknown output %q", bOpts.Output)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &ArtifactResult{
+		Format:    bOpts.Output,
+		Size:      info.Size(),
+		ExpiresAt: info.ModTime().Add(defaultRedisMsgTTL).UTC(),
+	}, nil
+}
+
+// writeDockerArchive writes the image in the `docker save` format, tagged
+// with its name and tag
+func writeDockerArchive(dst string, opts ImageOptions, image v1.Image) error {
+	tag, err := name.NewTag(opts.Name+":"+opts.Tag, name.WeakValidation)
+	if err != nil {
+		return fmt.Errorf("getting tag of the image: %w", err)
+	}
+	return tarball.WriteToFile(dst, tag, image)
+}
+
+// writeOCIArchive writes the image or the index as an OCI image layout in
+// layoutDir, then archives the layout into dst
+func writeOCIArchive(dst, layoutDir string, image v1.Image, index v1.ImageIndex) error {
+	if index == nil {
+		index = mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: image})
+	}
+	if _, err := layout.Write(layoutDir, index); err != nil {
+		return fmt.Errorf("writing OCI layout: %w", err)
+	}
+	return tarDir(layoutDir, dst)
+}
+
+// tarDir archives the regular files and directories of dir into dst
+func tarDir(dir, dst string) error {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// sweepArtifacts deletes the artifacts which outlived their build status,
+// until ctx is done
+func (b *Builder) sweepArtifacts(ctx context.Context) {
+	ticker := time.NewTicker(artifactSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.deleteExpiredArtifacts(time.Now()); err != nil {
+				b.logger.Error("deleting expired artifacts", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (b *Builder) deleteExpiredArtifacts(now time.Time) error {
+	entries, err := os.ReadDir(b.artifactsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tar") && !strings.HasSuffix(e.Name(), ".tar.tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < defaultRedisMsgTTL {
+			continue
+		}
+		if err := os.Remove(path.Join(b.artifactsDir, e.Name())); err != nil && !os.IsNotExist(err) {
+			b.logger.Error("deleting expired artifact", zap.String("name", e.Name()), zap.Error(err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOutput(t *testing.T) {
+	assert.NoError(t, validateOutput("", []string{"linux/amd64"}))
+	assert.NoError(t, validateOutput(OutputRegistry, []string{"linux/amd64", "linux/arm64"}))
+	assert.NoError(t, validateOutput(OutputTar, []string{"linux/amd64"}))
+	assert.NoError(t, validateOutput(OutputOCI, []string{"linux/amd64", "linux/arm64"}))
+	assert.Error(t, validateOutput(OutputTar, []string{"linux/amd64", "linux/arm64"}), "docker archives cannot hold multi-platform images")
+	assert.Error(t, validateOutput("zip", nil))
+}
+
+func TestBuildArtifact(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	testCases := []struct {
+		output    string
+		platforms []string
+	}{
+		{output: OutputTar, platforms: []string{"linux/amd64"}},
+		{output: OutputOCI, platforms: []string{"linux/amd64", "linux/arm64"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.output, func(t *testing.T) {
+			bOpts := BuilderOptions{
+				DockerfilePath: defaultDockerfilePath,
+				Git:            GitOptions{URL: "github.com/test-username/test-repo"},
+				Platforms:      tc.platforms,
+				Image:          ImageOptions{Name: "artifact-" + tc.output, Tag: "1h", Destination: defaultImageDestination},
+				Output:         tc.output,
+			}
+			require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+
+			_, _, err := b.Artifact(bOpts.Image.Name)
+			assert.ErrorIs(t, err, ErrArtifactNotFound, "There is no artifact before the build")
+
+			require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+
+			f, bd, err := b.Artifact(bOpts.Image.Name)
+			require.NoError(t, err)
+			defer f.Close()
+
+			require.NotNil(t, bd.Artifact)
+			assert.Equal(t, tc.output, bd.Artifact.Format)
+			info, err := f.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, info.Size(), bd.Artifact.Size)
+			require.NotNil(t, bd.Image, "The saved image should be described")
+			assert.Empty(t, bd.Image.Reference, "A saved image has no registry reference")
+
+			var names []string
+			tr := tar.NewReader(f)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				names = append(names, hdr.Name)
+			}
+			if tc.output == OutputTar {
+				assert.Contains(t, names, "manifest.json")
+			} else {
+				assert.Contains(t, names, "oci-layout")
+				assert.Contains(t, names, "index.json")
+			}
+		})
+	}
+
+	assert.Empty(t, kaniko.pushedTo, "Artifacts should not be pushed")
+	for _, build := range kaniko.builds {
+		assert.False(t, build.Cache, "Artifacts should not be cached in their destination")
+	}
+}
+
+func TestDeleteExpiredArtifacts(t *testing.T) {
+	b, _ := newTestBuilder(t)
+
+	fresh := b.artifactPath("fresh")
+	expired := b.artifactPath("expired")
+	for _, p := range []string{fresh, expired} {
+		require.NoError(t, os.WriteFile(p, []byte("tar"), 0644))
+	}
+	old := time.Now().Add(-defaultRedisMsgTTL - time.Minute)
+	require.NoError(t, os.Chtimes(expired, old, old))
+
+	require.NoError(t, b.deleteExpiredArtifacts(time.Now()))
+	assert.FileExists(t, fresh)
+	assert.NoFileExists(t, expired)
+}
@@ -0,0 +1,95 @@
+package builder
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// BuildBackend builds the image of a single build request. Backends only
+// build: the queue, the build status, the retries and the workspaces are
+// handled by the Builder, see WithBackend.
+type BuildBackend interface {
+	// Build builds the image of req, reports its progress and result to
+	// events, and pushes it or saves it to req.ArtifactPath. It must stop
+	// once ctx is done.
+	Build(ctx context.Context, req BuildRequest, events BuildEvents) error
+}
+
+// BuildRequest is a validated build request, with what the Builder prepared
+// for it
+type BuildRequest struct {
+	// Options is the build request, with its secrets decrypted
+	Options BuilderOptions
+
+	// Workspace is an empty directory of the build, deleted once it is done
+	Workspace string
+
+	// ContextArchive is the tar.gz build context and ContextDir the
+	// directory build context staged in the workspace. Both are empty for
+	// git contexts, which the backend fetches itself.
+	ContextArchive string
+	ContextDir     string
+
+	// ArtifactPath is where the image is saved when it is not pushed, in the
+	// format of Options.Output
+	ArtifactPath string
+}
+
+// BuildEvents receives the progress of a build from its backend
+type BuildEvents interface {
+	// Log appends to the build logs. Secrets are redacted by the Builder.
+	Log(logs string)
+
+	// Report records a result in the build status as soon as it is known.
+	// Only the fields which are set are recorded, and the cache steps are
+	// appended to the previous ones.
+	Report(out BuildOutput) error
+}
+
+// BuildOutput is what a backend reports about a build
+type BuildOutput struct {
+	Commit       string // resolved commit SHA of a git context
+	CacheSteps   []CacheStep
+	Platforms    []PlatformResult
+	Image        *ImageResult
+	Destinations []DestinationResult
+	Artifact     *ArtifactResult
+}
+
+// buildEvents records the progress reported by the backend in the build
+// status
+type buildEvents struct {
+	b      *Builder
+	name   string
+	redact *redactor
+}
+
+func (e *buildEvents) Log(logs string) {
+	err := e.b.UpdateBuildStatus(e.name, BuildStatusData{Logs: e.redact.Redact(logs)})
+	if err != nil {
+		e.b.logger.Error("adding logs to the build status:", zap.Error(err))
+	}
+}
+
+func (e *buildEvents) Report(out BuildOutput) error {
+	for i, step := range out.CacheSteps {
+		out.CacheSteps[i].Command = e.redact.Redact(step.Command)
+	}
+	for i, d := range out.Destinations {
+		if d.Error == "" {
+			continue
+		}
+		out.Destinations[i].Error = e.redact.Redact(d.Error)
+		e.b.logger.Error("pushing image", zap.String("reference", d.Reference), zap.String("error", out.Destinations[i].Error))
+	}
+
+	return e.b.UpdateBuildStatus(e.name, BuildStatusData{
+		Commit:       out.Commit,
+		CacheSteps:   out.CacheSteps,
+		Platforms:    out.Platforms,
+		Image:        out.Image,
+		Destinations: out.Destinations,
+		Artifact:     out.Artifact,
+	})
+}
@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend reports a fixed build instead of building anything
+type fakeBackend struct {
+	reqs      []BuildRequest
+	workspace bool // if the workspace existed during the build
+}
+
+func (f *fakeBackend) Build(_ context.Context, req BuildRequest, events BuildEvents) error {
+	f.reqs = append(f.reqs, req)
+	_, err := os.Stat(req.Workspace)
+	f.workspace = err == nil
+
+	events.Log("using token secret\n")
+	return events.Report(BuildOutput{
+		Commit: strings.Repeat("a", 40),
+		Destinations: []DestinationResult{
+			{Reference: "ttl.sh/image:1h", Pushed: true},
+			{Reference: "registry.example.com/image:1h", Error: "denied for token secret"},
+		},
+	})
+}
+
+func TestBuildBackend(t *testing.T) {
+	backend := &fakeBackend{}
+	b, kaniko := newTestBuilder(t, WithBackend(backend))
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git:     GitOptions{URL: "github.com/test-username/test-repo"},
+		Image:   ImageOptions{Name: "backend"},
+		Secrets: map[string]string{"TOKEN": "secret"},
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	b.runBuild(context.Background(), bOpts)
+
+	require.Len(t, backend.reqs, 1)
+	req := backend.reqs[0]
+	assert.Equal(t, "secret", req.Options.Secrets["TOKEN"], "The backend should get the decrypted secrets")
+	assert.Empty(t, req.ContextArchive, "Git contexts are fetched by the backend")
+	assert.Empty(t, req.ContextDir)
+	assert.True(t, backend.workspace)
+	assert.NoDirExists(t, req.Workspace)
+	assert.Empty(t, kaniko.builds, "Kaniko should not be used")
+
+	bd, err := b.GetBuildStatus("backend")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, bd.Status)
+	assert.Equal(t, strings.Repeat("a", 40), bd.Commit)
+	assert.Contains(t, bd.Logs, "using token ")
+	assert.NotContains(t, bd.Logs, "secret")
+	require.Len(t, bd.Destinations, 2)
+	assert.NotContains(t, bd.Destinations[1].Error, "secret")
+}
@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var buildArgNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateBuildArgs makes sure every build arg is in the KEY=VALUE format,
+// no key is given twice and no build arg clashes with a secret name. Secret
+// names are also the names of their files, see secretsDir.
+func validateBuildArgs(buildArgs []string, secrets map[string]string) error {
+	seen := make(map[string]bool, len(buildArgs))
+	for _, arg := range buildArgs {
+		key, _, found := strings.Cut(arg, "=")
+		if !found {
+			return fmt.Errorf("build arg %q must be in the KEY=VALUE format", arg)
+		}
+		if !buildArgNameRegex.MatchString(key) {
+			return fmt.Errorf("build arg %q has an invalid name", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("build arg %q is given more than once", key)
+		}
+		seen[key] = true
+	}
+
+	for name := range secrets {
+		if !buildArgNameRegex.MatchString(name) {
+			return fmt.Errorf("secret %q has an invalid name", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("secret %q is also given as a build arg", name)
+		}
+	}
+	return nil
+}
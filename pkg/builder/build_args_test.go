@@ -0,0 +1,54 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBuildArgs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		buildArgs     []string
+		secrets       map[string]string
+		expectedError bool
+	}{
+		{
+			name:      "Valid build args and secrets",
+			buildArgs: []string{"VERSION=1.0", "EMPTY="},
+			secrets:   map[string]string{"TOKEN": "s3cr3t"},
+		},
+		{
+			name:          "Missing value separator",
+			buildArgs:     []string{"VERSION"},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid name",
+			buildArgs:     []string{"1VERSION=1.0"},
+			expectedError: true,
+		},
+		{
+			name:          "Duplicate build arg",
+			buildArgs:     []string{"VERSION=1.0", "VERSION=2.0"},
+			expectedError: true,
+		},
+		{
+			name:          "Secret clashing with a build arg",
+			buildArgs:     []string{"TOKEN=public"},
+			secrets:       map[string]string{"TOKEN": "s3cr3t"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateBuildArgs(tc.buildArgs, tc.secrets)
+			if tc.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Unexpected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// MaxContextArchiveSize bounds the uploaded and downloaded context
+	// archives. Uploads are kept in redis until a worker runs the build.
+	MaxContextArchiveSize = 100 << 20
+
+	contextArchiveKeySuffix = ":context"
+	contextArchiveName      = "context.tar.gz"
+	localContextCopyName    = "local-context"
+
+	tarContextPrefix = "tar://"
+	dirContextPrefix = "dir://"
+)
+
+var (
+	ErrContextArchiveTooLarge = fmt.Errorf("build context archive exceeds %d bytes", MaxContextArchiveSize)
+	ErrContextAddressBlocked  = errors.New("context url resolves to a loopback, private, link-local or multicast address")
+)
+
+// maxContextRedirects bounds the redirects followed when downloading a
+// context archive
+const maxContextRedirects = 10
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ContextOptions selects a build context which is not a git repository.
+// A build has exactly one context: a git url, a URL, a local directory or an
+// uploaded archive.
+type ContextOptions struct {
+	URL      string `json:"url,omitempty"`       // HTTP(S) URL of a tar.gz archive
+	LocalDir string `json:"local_dir,omitempty"` // directory on the server, see WithLocalContextRoot
+	Uploaded bool   `json:"uploaded,omitempty"`  // set when the request carries a ContextArchive
+}
+
+// validateContext checks the build context of a request, which may have none
+// when optional is set
+func (b *Builder) validateContext(opts *BuilderOptions, optional bool) error {
+	opts.Context.Uploaded = opts.ContextArchive != nil
+
+	sources := 0
+	for _, set := range []bool{opts.Git.URL != "", opts.Context.URL != "", opts.Context.LocalDir != "", opts.Context.Uploaded} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 || sources == 0 && !optional {
+		return errors.New("exactly one build context is required: a git url, a context url, a local directory or an uploaded archive")
+	}
+
+	switch {
+	case opts.Context.Uploaded:
+		if len(opts.ContextArchive) > MaxContextArchiveSize {
+			return ErrContextArchiveTooLarge
+		}
+		if !strings.HasPrefix(string(opts.ContextArchive), string(gzipMagic)) {
+			return errors.New("uploaded build context must be a tar.gz archive")
+		}
+
+	case opts.Context.URL != "":
+		u, err := url.Parse(opts.Context.URL)
+		if err != nil {
+			return fmt.Errorf("parsing context url: %w", err)
+		}
+		if err := checkContextURL(u, b.contextURLAllowedHosts); err != nil {
+			return err
+		}
+
+	case opts.Context.LocalDir != "":
+		if _, err := b.localContextDir(opts.Context.LocalDir); err != nil {
+			return err
+		}
+	}
+
+	if opts.Git.URL == "" && opts.Git.Credentials != "" {
+		return errors.New("git credentials require a git url")
+	}
+	return nil
+}
+
+// localContextDir resolves a local context directory, which must be inside
+// the local context root
+func (b *Builder) localContextDir(dir string) (string, error) {
+	if b.localContextRoot == "" {
+		return "", errors.New("local build contexts are disabled")
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("local context directory %q must be an absolute path", dir)
+	}
+
+	root, err := filepath.EvalSymlinks(b.localContextRoot)
+	if err != nil {
+		return "", fmt.Errorf("resolving local context root: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolving local context directory: %w", err)
+	}
+
+	if !isWithin(root, resolved) {
+		return "", fmt.Errorf("local context directory %q is outside the local context root", dir)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("local context %q is not a directory", dir)
+	}
+	return resolved, nil
+}
+
+// validateContextDir checks that a context directory stays inside the build
+// context. Symlinks are only known once the context is fetched, they are
+// checked by resolveContextDir.
+func validateContextDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsLocal(dir) {
+		return fmt.Errorf("context dir %q must be a relative path inside the build context", dir)
+	}
+	return nil
+}
+
+// resolveContextDir returns the directory dir of the build context in root,
+// following its symlinks as long as they stay inside root
+func resolveContextDir(root, dir string) (string, error) {
+	if err := validateContextDir(dir); err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return root, nil
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(resolvedRoot, dir))
+	if err != nil {
+		return "", fmt.Errorf("resolving context dir: %w", err)
+	}
+	if !isWithin(resolvedRoot, resolved) {
+		return "", fmt.Errorf("context dir %q is outside the build context", dir)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("context dir %q is not a directory", dir)
+	}
+	return resolved, nil
+}
+
+// isWithin tells if path is root or one of its descendants. Both must be
+// clean absolute paths.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func contextArchiveKey(imageName string) string {
+	return imageName + contextArchiveKeySuffix
+}
+
+// storeContextArchive keeps an uploaded archive until a worker runs the build
+func (b *Builder) storeContextArchive(imageName string, archive []byte) error {
+	return b.redisClient.Set(contextArchiveKey(imageName), archive, defaultRedisMsgTTL).Err()
+}
+
+func (b *Builder) deleteContextArchive(imageName string) error {
+	return b.redisClient.Del(contextArchiveKey(imageName)).Err()
+}
+
+// stageContext copies or downloads the build context of a request into the
+// build workspace. It returns the path of the staged archive or directory,
+// both empty for git contexts which the backend fetches itself.
+func (b *Builder) stageContext(ctx context.Context, bOpts BuilderOptions, workspace string) (archive, dir string, err error) {
+	archivePath := path.Join(workspace, contextArchiveName)
+
+	switch {
+	case bOpts.Context.Uploaded:
+		data, err := b.redisClient.Get(contextArchiveKey(bOpts.Image.Name)).Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				return "", "", errors.New("uploaded build context not found, it may have expired")
+			}
+			return "", "", fmt.Errorf("getting uploaded build context: %w", err)
+		}
+		if err := os.WriteFile(archivePath, data, 0600); err != nil {
+			return "", "", err
+		}
+		return archivePath, "", nil
+
+	case bOpts.Context.URL != "":
+		if err := downloadContextArchive(ctx, b.contextClient, bOpts.Context.URL, archivePath); err != nil {
+			return "", "", fmt.Errorf("downloading build context: %w", err)
+		}
+		return archivePath, "", nil
+
+	case bOpts.Context.LocalDir != "":
+		// The local context root may have changed since the build was queued
+		src, err := b.localContextDir(bOpts.Context.LocalDir)
+		if err != nil {
+			return "", "", err
+		}
+		// The build works on a copy, so it cannot modify the directory
+		dir := path.Join(workspace, localContextCopyName)
+		if err := copyDir(src, dir); err != nil {
+			return "", "", fmt.Errorf("copying local build context: %w", err)
+		}
+		return "", dir, nil
+	}
+
+	return "", "", nil
+}
+
+// checkContextURL makes sure a context url is an http or https url of an
+// allowed host. Any host is allowed if allowedHosts is empty.
+func checkContextURL(u *url.URL, allowedHosts []string) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("context url %q must be an http or https url", u.Redacted())
+	}
+	if len(allowedHosts) > 0 && !slices.Contains(allowedHosts, strings.ToLower(u.Hostname())) {
+		return fmt.Errorf("context url host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// isPublicIP tells if ip may be reached by context downloads, which must not
+// reach the services next to dockwiz
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// newContextClient returns the client downloading context archives. Since
+// the urls come from the users of the API, the addresses are checked when
+// connecting, after the names are resolved, and every redirect is checked
+// like the url itself.
+func newContextClient(allowedHosts []string, allowIP func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowIP(ip) {
+				return ErrContextAddressBlocked
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the host of the url
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxContextRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxContextRedirects)
+			}
+			return checkContextURL(req.URL, allowedHosts)
+		},
+	}
+}
+
+// downloadContextArchive downloads an archive of at most
+// MaxContextArchiveSize bytes into dst
+func downloadContextArchive(ctx context.Context, client *http.Client, srcURL, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srcURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return &retryableError{err: err}
+		}
+		return err
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(resp.Body, MaxContextArchiveSize+1))
+	if err != nil {
+		return err
+	}
+	if n > MaxContextArchiveSize {
+		return ErrContextArchiveTooLarge
+	}
+	return f.Close()
+}
+
+// copyDir copies the directories, regular files and symlinks of src into dst
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		// Sockets, devices and the like are not part of a build context
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
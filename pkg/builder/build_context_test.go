@@ -0,0 +1,236 @@
+package builder
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContext(t *testing.T) {
+	root := t.TempDir()
+	inside := filepath.Join(root, "service")
+	require.NoError(t, os.Mkdir(inside, 0755))
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+
+	b, _ := newTestBuilder(t, WithLocalContextRoot(root))
+	archive := []byte{0x1f, 0x8b, 0x08}
+
+	testCases := []struct {
+		name      string
+		opts      BuilderOptions
+		expectErr bool
+	}{
+		{name: "git", opts: BuilderOptions{Git: GitOptions{URL: "github.com/org/repo"}}},
+		{name: "url", opts: BuilderOptions{Context: ContextOptions{URL: "https://example.com/context.tar.gz"}}},
+		{name: "local dir", opts: BuilderOptions{Context: ContextOptions{LocalDir: inside}}},
+		{name: "upload", opts: BuilderOptions{ContextArchive: archive}},
+		{name: "no context", opts: BuilderOptions{}, expectErr: true},
+		{name: "two contexts", opts: BuilderOptions{Git: GitOptions{URL: "github.com/org/repo"}, ContextArchive: archive}, expectErr: true},
+		{name: "uploaded flag without archive", opts: BuilderOptions{Context: ContextOptions{Uploaded: true}}, expectErr: true},
+		{name: "not gzip", opts: BuilderOptions{ContextArchive: []byte("plain")}, expectErr: true},
+		{name: "unsupported url", opts: BuilderOptions{Context: ContextOptions{URL: "file:///etc"}}, expectErr: true},
+		{name: "local dir outside root", opts: BuilderOptions{Context: ContextOptions{LocalDir: outside}}, expectErr: true},
+		{name: "local dir traversal", opts: BuilderOptions{Context: ContextOptions{LocalDir: root + "/service/../.."}}, expectErr: true},
+		{name: "local dir symlink escape", opts: BuilderOptions{Context: ContextOptions{LocalDir: filepath.Join(root, "escape")}}, expectErr: true},
+		{name: "git credentials without git", opts: BuilderOptions{ContextArchive: archive, Git: GitOptions{Credentials: "github"}}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := b.validateContext(&tc.opts, false)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	disabled, _ := newTestBuilder(t)
+	err := disabled.validateContext(&BuilderOptions{Context: ContextOptions{LocalDir: inside}}, false)
+	assert.Error(t, err, "Local contexts should be disabled without a local context root")
+}
+
+func TestBuildUploadedContext(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+	archive := []byte{0x1f, 0x8b, 0x08, 0x00}
+
+	res, err := b.AddToBuildQueue(BuilderOptions{ContextArchive: archive})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.True(t, bOpts.Context.Uploaded)
+	assert.Nil(t, bOpts.ContextArchive, "The archive should not be queued")
+
+	workspace := filepath.Join(b.workspaceRoot, res.ImageName)
+	var staged []byte
+	kaniko.onBuild = func() {
+		staged, _ = os.ReadFile(filepath.Join(workspace, contextArchiveName))
+	}
+	b.runBuild(context.Background(), bOpts)
+
+	bd, err := b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, bd.Status, bd.ErrorMsg)
+	assert.Empty(t, bd.Commit, "Only git contexts have a commit")
+
+	assert.Equal(t, []string{"tar://" + filepath.Join(workspace, contextArchiveName)}, kaniko.contexts)
+	assert.Equal(t, archive, staged, "The archive should be staged in the workspace")
+	assert.NoDirExists(t, workspace, "The workspace should be deleted after the build")
+
+	_, err = b.redisClient.Get(contextArchiveKey(res.ImageName)).Bytes()
+	assert.Equal(t, redis.Nil, err, "The archive should be deleted after the build")
+}
+
+func TestStageContext(t *testing.T) {
+	archive := []byte{0x1f, 0x8b, 0x08, 0x00}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/context.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	src := filepath.Join(root, "service")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "Dockerfile"), []byte("FROM scratch\n"), 0644))
+
+	b, _ := newTestBuilder(t, WithLocalContextRoot(root))
+	// The test server listens on a loopback address
+	b.contextClient = newContextClient(nil, func(net.IP) bool { return true })
+	workspace := t.TempDir()
+
+	archivePath, dir, err := b.stageContext(context.Background(), BuilderOptions{Context: ContextOptions{URL: server.URL + "/context.tar.gz"}}, workspace)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(workspace, contextArchiveName), archivePath)
+	assert.Empty(t, dir)
+	downloaded, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, archive, downloaded)
+
+	_, _, err = b.stageContext(context.Background(), BuilderOptions{Context: ContextOptions{URL: server.URL + "/missing"}}, workspace)
+	assert.Error(t, err, "Failed downloads should fail the build")
+
+	archivePath, dir, err = b.stageContext(context.Background(), BuilderOptions{Context: ContextOptions{LocalDir: src}}, workspace)
+	require.NoError(t, err)
+	assert.Empty(t, archivePath)
+	assert.Equal(t, filepath.Join(workspace, localContextCopyName), dir)
+	copied, err := os.ReadFile(filepath.Join(dir, "sub", "Dockerfile"))
+	require.NoError(t, err)
+	assert.Equal(t, "FROM scratch\n", string(copied), "The local directory should be copied into the workspace")
+
+	archivePath, dir, err = b.stageContext(context.Background(), BuilderOptions{Git: GitOptions{URL: "github.com/org/repo"}}, workspace)
+	require.NoError(t, err)
+	assert.Empty(t, archivePath, "Git contexts are fetched by the backend")
+	assert.Empty(t, dir)
+}
+
+func TestContextClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/context.tar.gz":
+			_, _ = w.Write([]byte{0x1f, 0x8b})
+		case "/metadata":
+			http.Redirect(w, r, "http://metadata.internal/latest", http.StatusFound)
+		case "/file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	dst := filepath.Join(t.TempDir(), contextArchiveName)
+
+	err := downloadContextArchive(context.Background(), newContextClient(nil, isPublicIP), server.URL+"/context.tar.gz", dst)
+	assert.ErrorIs(t, err, ErrContextAddressBlocked, "Loopback addresses should be blocked")
+
+	allowAll := func(net.IP) bool { return true }
+	client := newContextClient([]string{"127.0.0.1"}, allowAll)
+	require.NoError(t, downloadContextArchive(context.Background(), client, server.URL+"/context.tar.gz", dst))
+	assert.Error(t, downloadContextArchive(context.Background(), client, server.URL+"/metadata", dst), "Redirects to other hosts should be rejected")
+	assert.Error(t, downloadContextArchive(context.Background(), newContextClient(nil, allowAll), server.URL+"/file", dst), "Redirects to other schemes should be rejected")
+
+	for _, ip := range []string{"127.0.0.1", "10.0.0.1", "192.168.1.1", "169.254.169.254", "::1", "fe80::1", "0.0.0.0", "224.0.0.1", "::ffff:127.0.0.1"} {
+		assert.False(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+	assert.True(t, isPublicIP(net.ParseIP("1.1.1.1")))
+
+	b, _ := newTestBuilder(t, WithContextURLAllowedHosts("Artifacts.example.com"))
+	assert.NoError(t, b.validateContext(&BuilderOptions{Context: ContextOptions{URL: "https://artifacts.example.com/context.tar.gz"}}, false))
+	assert.Error(t, b.validateContext(&BuilderOptions{Context: ContextOptions{URL: "https://example.com/context.tar.gz"}}, false), "Hosts outside the allowlist should be rejected")
+}
+
+func TestResolveContextDir(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "services", "api"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "services", "file"), nil, 0644))
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink("services/api", filepath.Join(root, "api")))
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+
+	dir, err := resolveContextDir(root, "")
+	require.NoError(t, err)
+	assert.Equal(t, root, dir)
+
+	dir, err = resolveContextDir(root, "services/api")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(resolvedRoot, "services", "api"), dir)
+
+	dir, err = resolveContextDir(root, "api")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(resolvedRoot, "services", "api"), dir, "Symlinks inside the context should be followed")
+
+	for _, bad := range []string{"../", "services/../../etc", "/etc", "escape", "services/file", "missing"} {
+		_, err := resolveContextDir(root, bad)
+		assert.Error(t, err, "context dir %q should be rejected", bad)
+	}
+}
+
+func TestBuildContextDirAndTarget(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+	serviceDir := filepath.Join(kaniko.contextDir, "services", "api")
+	require.NoError(t, os.MkdirAll(serviceDir, 0755))
+
+	for _, opts := range []BuilderOptions{
+		{Git: GitOptions{URL: "github.com/org/repo"}, ContextDir: "../outside"},
+		{Git: GitOptions{URL: "github.com/org/repo"}, Target: "bad target"},
+	} {
+		_, err := b.AddToBuildQueue(opts)
+		assert.Error(t, err)
+	}
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git:        GitOptions{URL: "github.com/org/repo"},
+		ContextDir: "services/api",
+		Target:     "release",
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+
+	resolved, err := filepath.EvalSymlinks(serviceDir)
+	require.NoError(t, err)
+	require.Len(t, kaniko.builds, 1)
+	assert.Equal(t, resolved, kaniko.builds[0].SrcContext, "The context dir should be the build context")
+	assert.Equal(t, filepath.Join(resolved, defaultDockerfilePath), kaniko.builds[0].DockerfilePath, "The Dockerfile should be relative to the context dir")
+	assert.Equal(t, "release", kaniko.builds[0].Target)
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	assert.Equal(t, kaniko.commit, bd.Commit, "The commit should be resolved from the repository root")
+}
@@ -1,28 +1,74 @@
 package builder
 
-import "github.com/go-redis/redis"
+import (
+	"fmt"
+	"slices"
+
+	"github.com/go-redis/redis"
+)
+
+// maxStatusUpdateRetries is how many times a status update is retried when
+// the status is modified concurrently (e.g. a build is cancelled while its
+// logs are being written)
+const maxStatusUpdateRetries = 10
 
 func (b *Builder) SetBuildStatus(imageName string, data BuildStatusData) error {
 	return b.redisClient.Set(imageName, data, defaultRedisMsgTTL).Err()
 }
 
 func (b *Builder) UpdateBuildStatus(imageName string, data BuildStatusData) error {
-	bd, err := b.GetBuildStatus(imageName)
-	if err != nil {
-		return err
-	}
+	return b.modifyBuildStatus(imageName, func(bd *BuildStatusData) error {
+		if !data.EndTime.IsZero() {
+			bd.EndTime = data.EndTime
+		}
 
-	if !data.EndTime.IsZero() {
-		bd.EndTime = data.EndTime
-	}
+		// A cancelled build stays cancelled, whatever its worker reports
+		if data.Status != 0 && bd.Status != StatusCancelled {
+			bd.Status = data.Status
+		}
 
-	if data.Status != 0 {
-		bd.Status = data.Status
-	}
+		if data.Commit != "" {
+			bd.Commit = data.Commit
+		}
+
+		if data.Image != nil {
+			bd.Image = data.Image
+		}
+
+		if data.Artifact != nil {
+			bd.Artifact = data.Artifact
+		}
+
+		if data.Platforms != nil {
+			bd.Platforms = data.Platforms
+		}
 
-	bd.ErrorMsg = data.ErrorMsg
-	bd.Logs += data.Logs
-	return b.SetBuildStatus(imageName, bd)
+		// A retry only pushes to the destinations which failed, the results
+		// of the others are kept
+		for _, d := range data.Destinations {
+			i := slices.IndexFunc(bd.Destinations, func(r DestinationResult) bool { return r.Reference == d.Reference })
+			if i < 0 {
+				bd.Destinations = append(bd.Destinations, d)
+			} else {
+				bd.Destinations[i] = d
+			}
+		}
+
+		if data.Attempt != 0 {
+			bd.Attempt = data.Attempt
+		}
+		bd.Attempts = append(bd.Attempts, data.Attempts...)
+
+		// The reason of a scheduled retry stays until the next attempt
+		// ends, but a succeeded build has no error
+		if data.ErrorMsg != "" || data.Status == StatusSucceeded {
+			bd.ErrorMsg = data.ErrorMsg
+		}
+		bd.Logs += data.Logs
+		// Cache steps are parsed from the logs, so they are appended alike
+		bd.CacheSteps = append(bd.CacheSteps, data.CacheSteps...)
+		return nil
+	})
 }
 
 func (b *Builder) GetBuildStatus(imageName string) (BuildStatusData, error) {
@@ -36,3 +82,34 @@ func (b *Builder) GetBuildStatus(imageName string) (BuildStatusData, error) {
 	}
 	return data, nil
 }
+
+// modifyBuildStatus atomically applies modify to the build status.
+// If modify returns an error, the status is left untouched.
+func (b *Builder) modifyBuildStatus(imageName string, modify func(bd *BuildStatusData) error) error {
+	txf := func(tx *redis.Tx) error {
+		var bd BuildStatusData
+		if err := tx.Get(imageName).Scan(&bd); err != nil {
+			if err == redis.Nil {
+				return ErrBuildNotFound
+			}
+			return err
+		}
+
+		if err := modify(&bd); err != nil {
+			return err
+		}
+
+		_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+			return pipe.Set(imageName, bd, defaultRedisMsgTTL).Err()
+		})
+		return err
+	}
+
+	for i := 0; i < maxStatusUpdateRetries; i++ {
+		err := b.redisClient.Watch(txf, imageName)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("updating build status of %s: too many concurrent updates", imageName)
+}
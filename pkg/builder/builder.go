@@ -4,64 +4,245 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/url"
-	"os"
-	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
-	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/celestiaorg/dockwiz/pkg/lint"
 	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
-	"github.com/containerd/containerd/platforms"
 	"github.com/go-redis/redis"
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
 	"go.uber.org/zap"
 )
 
-func NewBuilder(redisClient *redis.Client, logger *zap.Logger) *Builder {
-	return &Builder{
-		redisClient: redisClient,
-		logger:      logger,
-		Queue:       redisqueue.NewQueue(redisClient, "build_queue"),
-		kaniko:      &Kaniko{},
+func NewBuilder(redisClient *redis.Client, logger *zap.Logger, opts ...Option) *Builder {
+	b := &Builder{
+		redisClient:  redisClient,
+		logger:       logger,
+		Queue:        redisqueue.NewQueue(redisClient, "build_queue"),
+		activeBuilds: map[string]struct{}{},
+		instanceID:   uuid.NewString(),
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.credentials == nil {
+		b.credentials = credentials.NewStore(nil)
+	}
+
+	if b.linter == nil {
+		b.linter = lint.NewLinter(lint.DefaultRules()...)
+	}
+
+	if b.retryMaxAttempts < 1 {
+		b.retryMaxAttempts = defaultRetryMaxAttempts
+	}
+	if b.retryBackoff <= 0 {
+		b.retryBackoff = defaultRetryBackoff
+	}
+
+	if b.visibilityTimeout > 0 && b.visibilityTimeout < minVisibilityTimeout {
+		b.visibilityTimeout = minVisibilityTimeout
+	}
+
+	if b.maxBuildTimeout <= 0 {
+		b.maxBuildTimeout = defaultMaxBuildTimeout
+	}
+
+	if b.cacheTTL <= 0 {
+		b.cacheTTL = defaultCacheTTL
+	}
+
+	b.contextClient = newContextClient(b.contextURLAllowedHosts, isPublicIP)
+
+	if b.workspaceRoot == "" {
+		b.workspaceRoot = defaultKanikoPath
+	}
+	if b.artifactsDir == "" {
+		b.artifactsDir = defaultArtifactsDir
+	}
+
+	if b.backend == nil {
+		b.backend = newKanikoBackend(b)
+		// The workspaces and artifacts must never end up in the snapshots of
+		// in-process builds
+		for _, p := range b.ignorePaths() {
+			util.AddToDefaultIgnoreList(util.IgnoreListEntry{Path: p})
+		}
+	}
+
+	if b.starvationLimit > 0 {
+		b.Queue.SetStarvationLimit(b.starvationLimit)
+	}
+
+	if b.ownerLimit > 0 || len(b.ownerLimits) > 0 {
+		if b.visibilityTimeout > 0 {
+			b.Queue.SetOwnerLimits(b.ownerLimit, b.ownerLimits)
+		} else {
+			logger.Warn("the running builds of an owner can only be limited with a visibility timeout, ignoring the owner limits")
+		}
+	}
+
+	if b.secretsKey == nil {
+		logger.Warn("no secrets key is configured, builds with secrets are rejected")
+	}
+	return b
 }
 
-func (b *Builder) AddToBuildQueue(opts BuilderOptions) (BuildResult, error) {
-	// preparation
+// prepareBuild fills in the defaults of a build request and validates it
+func (b *Builder) prepareBuild(opts *BuilderOptions) error {
+	return b.prepareRequest(opts, false)
+}
+
+// prepareRequest fills in the defaults of a request and validates it. Unlike
+// a build, a request with an inline Dockerfile may have no build context when
+// contextOptional is set.
+func (b *Builder) prepareRequest(opts *BuilderOptions, contextOptional bool) error {
 	if opts.Image.Name == "" {
 		opts.Image.Name = opts.Image.Prefix + uuid.New().String()
 	}
+	if err := validateImageName(opts.Image.Name); err != nil {
+		return err
+	}
 
 	if opts.Image.Tag == "" {
 		opts.Image.Tag = defaultImageTag
 	}
 
-	if opts.Image.Destination == "" {
+	if opts.Image.Destination == "" && len(opts.Image.Destinations) == 0 {
 		opts.Image.Destination = defaultImageDestination
 	}
 
-	if opts.DockerfilePath == "" {
+	for _, d := range opts.Image.Destinations {
+		if d.Registry == "" {
+			return errors.New("destination registry is required")
+		}
+		if d.Credentials != "" {
+			if _, err := b.credentials.Get(d.Credentials); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.DockerfileContent != "" {
+		if opts.DockerfilePath != "" {
+			return errors.New("only one of dockerfile path and dockerfile content can be given")
+		}
+		if err := validateDockerfileContent(opts.DockerfileContent); err != nil {
+			return err
+		}
+	} else if opts.DockerfilePath == "" {
 		opts.DockerfilePath = defaultDockerfilePath
 	}
 
-	if opts.Git.Branch == "" {
-		opts.Git.Branch = defaultGitBranch
+	if err := b.validateContext(opts, contextOptional && opts.DockerfileContent != ""); err != nil {
+		return err
+	}
+
+	if opts.Git.URL != "" {
+		cleanURL, err := cleanGhURL(opts.Git.URL)
+		if err != nil {
+			return fmt.Errorf("cleaning git url: %w", err)
+		}
+		opts.Git.URL = cleanURL
+
+		if err := validateGitOptions(&opts.Git); err != nil {
+			return err
+		}
+
+		if opts.Git.Credentials != "" {
+			if _, err := b.credentials.Get(opts.Git.Credentials); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	opts.Platforms, err = resolvePlatforms(opts.CustomPlatform, opts.Platforms)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.buildTimeout(*opts); err != nil {
+		return err
+	}
+
+	if _, _, err := b.retryPolicy(opts.Retry); err != nil {
+		return err
+	}
+
+	if err := validateContextDir(opts.ContextDir); err != nil {
+		return err
+	}
+
+	if err := validatePriority(opts.Priority); err != nil {
+		return err
+	}
+
+	if err := validateOwner(queueOwner(*opts)); err != nil {
+		return err
+	}
+
+	if opts.Target != "" && !targetRegex.MatchString(opts.Target) {
+		return fmt.Errorf("invalid target stage %q", opts.Target)
+	}
+
+	if err := validateOutput(opts.Output, opts.Platforms); err != nil {
+		return err
+	}
+
+	if err := validateCacheOptions(opts.Cache); err != nil {
+		return err
+	}
+
+	if err := validateLabels(opts.Labels); err != nil {
+		return err
+	}
+
+	// Secrets sealed with a random key could not be opened by the other
+	// instances, nor after a restart
+	if len(opts.Secrets) > 0 && b.secretsKey == nil {
+		return ErrNoSecretsKey
 	}
 
-	if opts.Git.URL == "" {
-		return BuildResult{}, errors.New("git url is required")
+	return validateBuildArgs(opts.BuildArgs, opts.Secrets)
+}
+
+func (b *Builder) AddToBuildQueue(opts BuilderOptions) (BuildResult, error) {
+	nameGenerated := opts.Image.Name == ""
+	// Only the builder sets these, a client must not skip retries
+	opts.DedupKey = ""
+	opts.Attempt = 0
+	if err := b.prepareBuild(&opts); err != nil {
+		return BuildResult{}, err
 	}
 
-	cleanURL, err := cleanGhURL(opts.Git.URL)
+	if b.dedupWindow > 0 && !opts.NoDedup {
+		res, ok, err := b.deduplicate(&opts, nameGenerated)
+		if err != nil {
+			return BuildResult{}, err
+		}
+		if ok {
+			return res, nil
+		}
+	}
+
+	if opts.Lint {
+		if err := b.preflightLint(opts); err != nil {
+			return BuildResult{}, err
+		}
+	}
+
+	// Secrets must never reach redis in plaintext
+	var err error
+	opts.EncryptedSecrets, err = sealSecrets(b.secretsKey, opts.Secrets)
 	if err != nil {
-		return BuildResult{}, fmt.Errorf("cleaning git url: %w", err)
+		return BuildResult{}, fmt.Errorf("encrypting secrets: %w", err)
 	}
-	opts.Git.URL = cleanURL
+	opts.Secrets = nil
 
 	err = b.SetBuildStatus(opts.Image.Name, BuildStatusData{
 		Status:    StatusPending,
@@ -72,7 +253,14 @@ func (b *Builder) AddToBuildQueue(opts BuilderOptions) (BuildResult, error) {
 		return BuildResult{}, fmt.Errorf("setting build status: %w", err)
 	}
 
-	if err := b.Queue.Enqueue(opts); err != nil {
+	if opts.Context.Uploaded {
+		if err := b.storeContextArchive(opts.Image.Name, opts.ContextArchive); err != nil {
+			return BuildResult{}, fmt.Errorf("storing build context: %w", err)
+		}
+		opts.ContextArchive = nil
+	}
+
+	if err := b.Queue.EnqueueOwner(opts, opts.queuePriority(), queueOwner(opts)); err != nil {
 		return BuildResult{}, fmt.Errorf("adding build to the queue: %w", err)
 	}
 
@@ -83,75 +271,198 @@ func (b *Builder) AddToBuildQueue(opts BuilderOptions) (BuildResult, error) {
 }
 
 // Start starts the builder worker where it dequeues the build requests from the queue
-// and builds the images using Kaniko and pushes them to the registry
+// and builds the images using Kaniko and pushes them to the registry. Kaniko
+// builds unpack their base images into the root filesystem of the container,
+// so an instance runs a single build at a time.
 func (b *Builder) Start() {
 	b.logger.Info("Starting builder")
 	ctx, cancel := context.WithCancel(context.Background())
 	b.startCancelFunc = cancel
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		b.work(ctx, b.instanceID)
+	}()
+	go b.sweepArtifacts(ctx)
+	go b.promoteRetries(ctx)
+	go b.sweepWorkspaces(ctx)
+	if b.visibilityTimeout > 0 {
+		go b.reapBuilds(ctx)
+	}
+	// The leadership is released before the client is closed
+	b.workers.Add(1)
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
+		defer b.workers.Done()
+		b.runSchedules(ctx)
+	}()
+}
+
+// ignorePaths returns the directories of dockwiz which must be kept out of
+// the snapshots of the builds
+func (b *Builder) ignorePaths() []string {
+	return []string{b.workspaceRoot, b.artifactsDir, secretsDir}
+}
+
+// work runs the builds from the queue one after the other until ctx is done.
+// The consumer identifies the worker to the reliable queue.
+func (b *Builder) work(ctx context.Context, consumer string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			if !b.waitForDisk(ctx) {
 				return
-			default:
-				var bOpts BuilderOptions
-				if err := b.Queue.Dequeue(&bOpts); err != nil {
-					if err == redisqueue.ErrQueueEmpty {
-						// Sleep for a random seconds between 1 and 5
-						// This is to avoid a thundering herd problem on the redis server
-						// https://en.wikipedia.org/wiki/Thundering_herd_problem
-						time.Sleep(time.Duration(rand.Intn(5-1)+1) * time.Second)
-						continue
-					}
-					b.logger.Error("dequeue error", zap.Error(err))
-					continue
+			}
+
+			var bOpts BuilderOptions
+			lease, err := b.dequeue(ctx, consumer, &bOpts)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
 				}
+				b.logger.Error("dequeue error", zap.Error(err))
+				// Do not spin while redis is unavailable
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(dequeueErrorDelay):
+				}
+				continue
+			}
 
-				b.logger.Debug("Got image name from the queue", zap.String("image_name", bOpts.Image.Name))
+			b.logger.Debug("Got image name from the queue", zap.String("image_name", bOpts.Image.Name))
+			b.runLeasedBuild(ctx, bOpts, lease)
+		}
+	}
+}
 
-				bd, err := b.GetBuildStatus(bOpts.Image.Name)
-				if err != nil {
-					b.logger.Error("getting build status", zap.Error(err))
-					continue
-				}
+// runLeasedBuild runs a dequeued build if it is still pending, then
+// acknowledges its lease, if any
+func (b *Builder) runLeasedBuild(ctx context.Context, bOpts BuilderOptions, lease *redisqueue.Lease) {
+	if lease != nil {
+		defer func() {
+			if err := lease.Ack(); err != nil {
+				b.logger.Error("acknowledging build", zap.String("image_name", bOpts.Image.Name), zap.Error(err))
+			}
+		}()
+	}
 
-				// In a rare case, another instance might be front running the build
-				if bd.Status != StatusPending {
-					b.logger.Debug("build status is not pending, skipping", zap.String("image_name", bOpts.Image.Name))
-					continue
-				}
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	if err != nil {
+		b.logger.Error("getting build status", zap.Error(err))
+		return
+	}
+
+	// In a rare case, another instance might be front running the build
+	if bd.Status != StatusPending {
+		b.logger.Debug("build status is not pending, skipping", zap.String("image_name", bOpts.Image.Name))
+		return
+	}
 
-				b.logger.Debug("starting build", zap.String("image_name", bOpts.Image.Name))
+	if lease != nil {
+		leaseCtx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		go b.keepLease(leaseCtx, lease, bOpts.Image.Name, cancel)
+		ctx = leaseCtx
+	}
 
-				bErr := b.build(bOpts)
+	b.logger.Debug("starting build", zap.String("image_name", bOpts.Image.Name))
+	b.runBuild(ctx, bOpts)
+}
 
-				var (
-					status  = StatusSucceeded
-					bErrMsg = ""
-				)
-				if bErr != nil {
-					status = StatusFailed
-					bErrMsg = bErr.Error()
-					b.logger.Error("build error:", zap.Error(bErr))
-				}
+// runBuild builds a dequeued build request and records its final status
+func (b *Builder) runBuild(ctx context.Context, bOpts BuilderOptions) {
+	buildCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go b.watchCancellation(buildCtx, bOpts.Image.Name, cancel)
 
-				err = b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{
-					Status:   status,
-					ErrorMsg: bErrMsg,
-					EndTime:  time.Now().UTC(),
-					Logs:     fmt.Sprintf("Build finished with status %s\n", status.String()),
-				})
-				if err != nil {
-					b.logger.Error("updating build status:", zap.Error(err))
-				}
-			}
+	// Requests are validated when queued, so this only fails if the server
+	// max timeout was lowered since; the max timeout is used then
+	timeout, err := b.buildTimeout(bOpts)
+	if err != nil {
+		timeout = b.maxBuildTimeout
+	}
+	buildCtx, cancelTimeout := context.WithTimeoutCause(buildCtx, timeout, ErrBuildTimedOut)
+	defer cancelTimeout()
+
+	var bErr error
+	bOpts.Secrets, bErr = openSecrets(b.secretsKey, bOpts.EncryptedSecrets)
+	redact := b.buildRedactor(bOpts)
+	if bErr == nil {
+		bErr = b.build(buildCtx, bOpts, redact)
+	}
+
+	var (
+		status  = StatusSucceeded
+		bErrMsg = ""
+	)
+	switch {
+	case errors.Is(context.Cause(buildCtx), errLeaseLost):
+		// The build was requeued, its status is not this worker's anymore
+		b.logger.Warn("build lease lost, abandoning build", zap.String("image_name", bOpts.Image.Name))
+		return
+	case errors.Is(context.Cause(buildCtx), ErrBuildCancelled):
+		status = StatusCancelled
+		b.logger.Info("build cancelled", zap.String("image_name", bOpts.Image.Name))
+	case errors.Is(context.Cause(buildCtx), ErrBuildTimedOut):
+		status = StatusTimedOut
+		bErrMsg = fmt.Sprintf("build exceeded its timeout of %s", timeout)
+		b.logger.Info("build timed out", zap.String("image_name", bOpts.Image.Name), zap.Duration("timeout", timeout))
+	case bErr != nil:
+		status = StatusFailed
+		bErrMsg = redact.Redact(bErr.Error())
+		b.logger.Error("build error:", zap.String("error", bErrMsg))
+	}
+
+	if status == StatusFailed && isTransient(bErr) {
+		retried, err := b.scheduleRetry(retryOf(bOpts, bErr), bErrMsg)
+		if err != nil {
+			b.logger.Error("retrying build:", zap.String("image_name", bOpts.Image.Name), zap.Error(err))
 		}
-	}()
+		if retried {
+			b.logger.Info("build retry scheduled", zap.String("image_name", bOpts.Image.Name), zap.Int("attempt", max(bOpts.Attempt, 1)))
+			return
+		}
+	}
+
+	if bOpts.Context.Uploaded {
+		if err := b.deleteContextArchive(bOpts.Image.Name); err != nil {
+			b.logger.Error("deleting uploaded build context:", zap.Error(err))
+		}
+	}
+
+	var attempts []AttemptResult
+	endTime := time.Now().UTC()
+	if status == StatusFailed {
+		attempts = []AttemptResult{{Attempt: max(bOpts.Attempt, 1), Error: bErrMsg, EndTime: endTime}}
+	}
+	err = b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{
+		Status:   status,
+		ErrorMsg: bErrMsg,
+		EndTime:  endTime,
+		Logs:     fmt.Sprintf("Build finished with status %s\n", status.String()),
+		Attempts: attempts,
+	})
+	if err != nil {
+		b.logger.Error("updating build status:", zap.Error(err))
+	}
+
+	if bOpts.DedupKey != "" {
+		if err := b.finishDedup(bOpts.DedupKey, status); err != nil {
+			b.logger.Error("extending build deduplication:", zap.Error(err))
+		}
+	}
 }
 
+// Close stops the workers, waits for them to return and closes the redis
+// client. Running builds are cancelled, but in-process builds only stop
+// between their steps.
 func (b *Builder) Close() error {
 	if b.startCancelFunc != nil {
 		b.startCancelFunc()
+		// Workers must not use the client once closed
+		b.workers.Wait()
 	}
 
 	if b.redisClient == nil {
@@ -160,90 +471,51 @@ func (b *Builder) Close() error {
 	return b.redisClient.Close()
 }
 
-func (b *Builder) build(bOpts BuilderOptions) error {
-	// Catch Kaniko logs and write them to the redis
-	logsHook := NewCatchLogsHook()
-	// Since Kaniko does not receive a logger, we need to add the hook to the global logrus logger
-	// Important: Right now using the global logger is the only way to catch the logs
-	//   on the other hand, as Kaniko does not support concurrent builds, this should not be a problem
-	logrus.AddHook(logsHook)
-	logChan, stop := logsHook.StreamNewLogs()
-	defer stop()
-
-	go func() {
-		for newLogs := range logChan {
-			err := b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{Logs: newLogs})
-			if err != nil {
-				b.logger.Error("adding logs to the build status:", zap.Error(err))
-			}
-		}
-	}()
-
-	err := b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusBuilding})
+// build runs a build on the backend, in a workspace with its staged context
+func (b *Builder) build(ctx context.Context, bOpts BuilderOptions, redact *redactor) error {
+	err := b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{
+		Status:  StatusBuilding,
+		Attempt: max(bOpts.Attempt, 1),
+	})
 	if err != nil {
 		return fmt.Errorf("updating build status: %w", err)
 	}
 
-	config.BuildContextDir = path.Join(defaultKanikoPath, bOpts.Image.Name)
-
-	// delete the directory if it already exist
-	if _, err := os.Stat(config.BuildContextDir); err == nil {
-		if err := os.RemoveAll(config.BuildContextDir); err != nil {
-			return err
-		}
-	}
-
-	dockerFilePath, err := filepath.Abs(filepath.Join(config.BuildContextDir, bOpts.DockerfilePath))
+	workspace, cleanup, err := b.newWorkspace(bOpts.Image.Name)
 	if err != nil {
-		return err
+		return fmt.Errorf("creating build workspace: %w", err)
 	}
+	defer cleanup()
 
-	kOpts := &config.KanikoOptions{
-		SrcContext: "git://" + bOpts.Git.URL,
-		Git: config.KanikoGitOptions{
-			Branch:            bOpts.Git.Branch,
-			SingleBranch:      bOpts.Git.SingleBranch,
-			RecurseSubmodules: bOpts.Git.RecurseSubmodules,
-		},
-		CustomPlatform: platforms.Format(platforms.Normalize(platforms.DefaultSpec())),
-		DockerfilePath: dockerFilePath,
-		SnapshotMode:   "full",
-		Destinations: []string{
-			fmt.Sprintf("%s/%s:%s",
-				bOpts.Image.Destination,
-				bOpts.Image.Name,
-				bOpts.Image.Tag),
-		},
-		Cache:   true,
-		Cleanup: false,
-	}
-
-	ctxExec, err := b.kaniko.GetBuildContext(kOpts.SrcContext, buildcontext.BuildOptions{
-		GitBranch:            kOpts.Git.Branch,
-		GitSingleBranch:      kOpts.Git.SingleBranch,
-		GitRecurseSubmodules: kOpts.Git.RecurseSubmodules,
-	})
+	req := BuildRequest{Options: bOpts, Workspace: workspace}
+	if !bOpts.pushesToRegistry() {
+		req.ArtifactPath = b.artifactPath(bOpts.Image.Name)
+	}
+	req.ContextArchive, req.ContextDir, err = b.stageContext(ctx, bOpts, workspace)
 	if err != nil {
 		return err
 	}
 
-	b.logger.Debug("Getting source context from", zap.String("src_context", kOpts.SrcContext))
+	return b.backend.Build(ctx, req, &buildEvents{b: b, name: bOpts.Image.Name, redact: redact})
+}
 
-	kOpts.SrcContext, err = ctxExec.UnpackTarFromBuildContext()
-	if err != nil {
-		return err
+// buildTimeout returns the timeout of a build, which cannot exceed the server max timeout
+func (b *Builder) buildTimeout(bOpts BuilderOptions) (time.Duration, error) {
+	if bOpts.Timeout == "" {
+		return b.maxBuildTimeout, nil
 	}
-	b.logger.Debug("Updated source context", zap.String("src_context", kOpts.SrcContext))
 
-	image, err := b.kaniko.DoBuild(kOpts)
+	timeout, err := time.ParseDuration(bOpts.Timeout)
 	if err != nil {
-		return fmt.Errorf("error building image: %w", err)
+		return 0, fmt.Errorf("invalid timeout: %w", err)
 	}
-	if err := b.kaniko.DoPush(image, kOpts); err != nil {
-		return fmt.Errorf("error pushing image: %w", err)
+	if timeout <= 0 {
+		return 0, errors.New("timeout must be positive")
 	}
-
-	return nil
+	if timeout > b.maxBuildTimeout {
+		return 0, fmt.Errorf("timeout %s exceeds the max build timeout of %s", timeout, b.maxBuildTimeout)
+	}
+	return timeout, nil
 }
 
 // cleanGhURL removes the scheme from a GitHub URL.
@@ -1,9 +1,12 @@
 package builder
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCleanGhURL(t *testing.T) {
@@ -40,3 +43,50 @@ func TestCleanGhURL(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildTimeout(t *testing.T) {
+	b, kaniko := newTestBuilder(t, WithMaxBuildTimeout(time.Minute))
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/test-repo"},
+		Image:          ImageOptions{Name: "slow", Tag: "1h", Destination: "ttl.sh"},
+		Timeout:        "100ms",
+	}
+
+	_, err := b.AddToBuildQueue(BuilderOptions{Git: bOpts.Git, Timeout: "2m"})
+	assert.Error(t, err, "A timeout above the max should be rejected")
+	_, err = b.AddToBuildQueue(BuilderOptions{Git: bOpts.Git, Timeout: "soon"})
+	assert.Error(t, err, "An invalid timeout should be rejected")
+
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+	kaniko.onBuild = func() {
+		time.Sleep(300 * time.Millisecond)
+	}
+	b.runBuild(context.Background(), bOpts)
+
+	assert.Empty(t, kaniko.pushedTo, "A timed out build should not be pushed")
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	assert.Equal(t, StatusTimedOut, bd.Status, "Build should be timed out")
+	assert.Contains(t, bd.ErrorMsg, "100ms", "The error should mention the timeout")
+}
+
+func TestWorkerBlockingDequeue(t *testing.T) {
+	b, _ := newTestBuilder(t)
+	b.Start()
+
+	// Let the worker wait on the empty queue
+	time.Sleep(100 * time.Millisecond)
+	res, err := b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: "github.com/test-username/test-repo"}})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		bd, err := b.GetBuildStatus(res.ImageName)
+		return err == nil && bd.Status == StatusSucceeded
+	}, time.Second, 10*time.Millisecond, "The waiting worker should pick the build right away")
+
+	start := time.Now()
+	require.NoError(t, b.Close())
+	assert.Less(t, time.Since(start), time.Second, "Idle workers should stop right away")
+}
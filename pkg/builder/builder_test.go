@@ -97,6 +97,50 @@ func TestAddToBuildQueue(t *testing.T) {
 	assert.Equal(t, opts.Git.URL, qOpts.Git.URL, "Git URL should match")
 }
 
+func TestAddToBuildQueueWithSecrets(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error should be nil when starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err, "Error should be nil when creating logger")
+	key, err := builder.ParseSecretsKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	require.NoError(t, err, "Error should be nil when parsing the secrets key")
+	b := builder.NewBuilder(rdb, logger, builder.WithSecretsKey(key))
+
+	opts := builder.BuilderOptions{
+		Git: builder.GitOptions{
+			URL: "github.com/test-username/test-repo",
+		},
+		BuildArgs: []string{"VERSION=1.0"},
+		Secrets:   map[string]string{"NPM_TOKEN": "s3cr3t-value"},
+	}
+
+	_, err = b.AddToBuildQueue(opts)
+	require.NoError(t, err, "Error should be nil when adding to build queue")
+
+	queued, err := rdb.LRange("build_queue:owner:github.com/test-username/test-repo", 0, -1).Result()
+	require.NoError(t, err, "Error should be nil when reading the queue")
+	require.Len(t, queued, 1, "Queue should have one item")
+	assert.NotContains(t, queued[0], "s3cr3t-value", "Secrets should not be queued in plaintext")
+
+	var qOpts builder.BuilderOptions
+	err = b.Queue.Dequeue(&qOpts)
+	require.NoError(t, err, "Error should be nil when dequeuing from the queue")
+	assert.Equal(t, opts.BuildArgs, qOpts.BuildArgs, "Build args should match")
+	assert.Empty(t, qOpts.Secrets, "Secrets should not be queued")
+	assert.NotEmpty(t, qOpts.EncryptedSecrets, "Encrypted secrets should be queued")
+
+	opts.BuildArgs = []string{"VERSION"}
+	_, err = b.AddToBuildQueue(opts)
+	assert.Error(t, err, "Invalid build args should be rejected")
+}
+
 func TestStartBuilder(t *testing.T) {
 	mr, err := miniredis.Run()
 	require.NoError(t, err, "Error should be nil when starting miniredis server")
@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// defaultCacheTTL is the Kaniko default for how long cached layers are used
+const defaultCacheTTL = 14 * 24 * time.Hour
+
+// cacheStepRegex matches the lines Kaniko logs when it looks up the cached
+// layer of a Dockerfile step
+var cacheStepRegex = regexp.MustCompile(`(No cached layer found for cmd|Using caching version of cmd:) (.+)$`)
+
+// CacheOptions configures the layer cache of a build
+type CacheOptions struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Repo     string `json:"repo,omitempty"` // defaults to the server cache repository
+}
+
+// CacheStep records if the layer of a Dockerfile step was found in the cache
+type CacheStep struct {
+	Command string `json:"command"`
+	Hit     bool   `json:"hit"`
+}
+
+func validateCacheOptions(opts CacheOptions) error {
+	if opts.Repo == "" {
+		return nil
+	}
+	if opts.Disabled {
+		return fmt.Errorf("a cache repository cannot be given when the cache is disabled")
+	}
+	if _, err := name.NewRepository(opts.Repo); err != nil {
+		return fmt.Errorf("invalid cache repository %q: %w", opts.Repo, err)
+	}
+	return nil
+}
+
+// parseCacheSteps extracts the cache lookups from the Kaniko logs
+func parseCacheSteps(logs string) []CacheStep {
+	var steps []CacheStep
+	for _, line := range strings.Split(logs, "\n") {
+		match := cacheStepRegex.FindStringSubmatch(logMessage(line))
+		if match == nil {
+			continue
+		}
+		steps = append(steps, CacheStep{
+			Command: match[2],
+			Hit:     strings.HasPrefix(match[1], "Using"),
+		})
+	}
+	return steps
+}
+
+// logMessage returns the message of a log line. The lines are either in the
+// format of the Kaniko executor (`INFO[0001] message`) or in the logfmt
+// format of the default logrus formatter (`level=info msg="message"`).
+func logMessage(line string) string {
+	if i := strings.Index(line, `msg="`); i >= 0 {
+		if quoted, err := strconv.QuotedPrefix(line[i+len("msg="):]); err == nil {
+			if msg, err := strconv.Unquote(quoted); err == nil {
+				return msg
+			}
+		}
+	}
+	return strings.TrimRight(line, " \r")
+}
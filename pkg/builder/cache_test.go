@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheSteps(t *testing.T) {
+	logs := "INFO[0001] Checking for cached layer ttl.sh/cache:abc...\n" +
+		"INFO[0001] Using caching version of cmd: RUN apk add git\n" +
+		"\x1b[36mINFO\x1b[0m[0002] No cached layer found for cmd RUN make build \n" +
+		"INFO[0003] Unpacking rootfs as cmd RUN make build requires it.\n" +
+		`time="2024-01-11T16:31:54Z" level=info msg="Using caching version of cmd: RUN echo \"done\""` + "\n"
+
+	assert.Equal(t, []CacheStep{
+		{Command: "RUN apk add git", Hit: true},
+		{Command: "RUN make build", Hit: false},
+		{Command: `RUN echo "done"`, Hit: true},
+	}, parseCacheSteps(logs))
+	assert.Empty(t, parseCacheSteps("INFO[0001] Built cross stage deps\n"))
+}
+
+func TestBuildCacheOptions(t *testing.T) {
+	b, kaniko := newTestBuilder(t, WithCacheRepo("registry.example.com/cache"), WithCacheTTL(time.Hour))
+	kaniko.onBuild = func() {
+		logrus.Info("Using caching version of cmd: RUN make deps")
+		logrus.Info("No cached layer found for cmd RUN make build")
+	}
+
+	for _, cache := range []CacheOptions{
+		{Repo: "not a repo"},
+		{Disabled: true, Repo: "registry.example.com/other"},
+	} {
+		_, err := b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: "github.com/org/repo"}, Cache: cache})
+		assert.Error(t, err)
+	}
+
+	for _, cache := range []CacheOptions{{}, {Repo: "registry.example.com/other"}, {Disabled: true}} {
+		bOpts := BuilderOptions{
+			DockerfilePath: defaultDockerfilePath,
+			Git:            GitOptions{URL: "github.com/org/repo"},
+			Image:          ImageOptions{Name: "cached", Tag: "1h", Destination: defaultImageDestination},
+			Cache:          cache,
+		}
+		require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+		require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+	}
+
+	require.Len(t, kaniko.builds, 3)
+	assert.True(t, kaniko.builds[0].Cache)
+	assert.Equal(t, "registry.example.com/cache", kaniko.builds[0].CacheRepo, "The server cache repo should be the default")
+	assert.Equal(t, time.Hour, kaniko.builds[0].CacheTTL)
+	assert.Equal(t, "registry.example.com/other", kaniko.builds[1].CacheRepo, "The request cache repo should be used")
+	assert.False(t, kaniko.builds[2].Cache, "The cache should be disabled")
+
+	bd, err := b.GetBuildStatus("cached")
+	require.NoError(t, err)
+	assert.Contains(t, bd.CacheSteps, CacheStep{Command: "RUN make deps", Hit: true})
+	assert.Contains(t, bd.CacheSteps, CacheStep{Command: "RUN make build", Hit: false})
+}
@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cancelPollInterval is how often a worker checks if its build was cancelled
+const cancelPollInterval = time.Second
+
+// Cancel stops a build. A pending build is removed from the queue, and the
+// worker running a build in progress aborts it. The logs collected so far
+// are kept in the build status.
+func (b *Builder) Cancel(imageName string) error {
+	var wasPending bool
+	err := b.modifyBuildStatus(imageName, func(bd *BuildStatusData) error {
+		if bd.Status.Finished() {
+			return ErrBuildNotCancellable
+		}
+
+		wasPending = bd.Status == StatusPending
+		bd.Status = StatusCancelled
+		bd.EndTime = time.Now().UTC()
+		bd.Logs += "Build cancelled\n"
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !wasPending {
+		return nil
+	}
+
+	// Workers skip builds which are not pending, so failing to remove the
+	// job from the queue is not critical
+	_, err = b.Queue.Remove(func(data []byte) bool {
+		var bOpts BuilderOptions
+		if err := json.Unmarshal(data, &bOpts); err != nil {
+			return false
+		}
+		return bOpts.Image.Name == imageName
+	})
+	if err != nil {
+		b.logger.Error("removing cancelled build from the queue", zap.String("image_name", imageName), zap.Error(err))
+	}
+
+	if err := b.deleteContextArchive(imageName); err != nil {
+		b.logger.Error("deleting uploaded build context", zap.String("image_name", imageName), zap.Error(err))
+	}
+	return nil
+}
+
+// watchCancellation cancels the build context with ErrBuildCancelled once the
+// build is cancelled. It returns when ctx is done.
+func (b *Builder) watchCancellation(ctx context.Context, imageName string, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bd, err := b.GetBuildStatus(imageName)
+			if err != nil {
+				b.logger.Error("checking build cancellation", zap.String("image_name", imageName), zap.Error(err))
+				continue
+			}
+			if bd.Status == StatusCancelled {
+				cancel(ErrBuildCancelled)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancel(t *testing.T) {
+	b, _ := newTestBuilder(t)
+
+	bOpts := BuilderOptions{
+		Git:   GitOptions{URL: "github.com/test-username/test-repo"},
+		Image: ImageOptions{Name: "pending"},
+	}
+	_, err := b.AddToBuildQueue(bOpts)
+	require.NoError(t, err, "Error should be nil when adding to build queue")
+
+	require.NoError(t, b.Cancel("pending"), "Error should be nil when cancelling a pending build")
+
+	bd, err := b.GetBuildStatus("pending")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, bd.Status, "Build should be cancelled")
+	assert.Contains(t, bd.Logs, "Building image pending", "Logs should be kept")
+
+	var qOpts BuilderOptions
+	assert.ErrorIs(t, b.Queue.Dequeue(&qOpts), redisqueue.ErrQueueEmpty, "Cancelled build should be removed from the queue")
+
+	assert.ErrorIs(t, b.Cancel("pending"), ErrBuildNotCancellable, "A finished build cannot be cancelled")
+	assert.ErrorIs(t, b.Cancel("missing"), ErrBuildNotFound, "Unknown builds cannot be cancelled")
+}
+
+func TestCancelRunningBuild(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/test-repo"},
+		Image:          ImageOptions{Name: "running", Tag: "1h", Destination: "ttl.sh"},
+	}
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+
+	kaniko.onBuild = func() {
+		assert.NoError(t, b.Cancel(bOpts.Image.Name), "Error should be nil when cancelling a running build")
+		// Give the worker the time to notice
+		time.Sleep(2 * cancelPollInterval)
+	}
+	b.runBuild(context.Background(), bOpts)
+
+	assert.Empty(t, kaniko.pushedTo, "A cancelled build should not be pushed")
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, bd.Status, "Build should be cancelled")
+	assert.Empty(t, bd.ErrorMsg, "A cancelled build has no error")
+	assert.Contains(t, bd.Logs, "Build cancelled", "Logs should be kept")
+}
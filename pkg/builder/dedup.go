@@ -0,0 +1,209 @@
+package builder
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	dedupKeyPrefix = "dedup:"
+
+	// dedupResolveTimeout bounds the resolution of the git revision of a
+	// build request, which is done before it is queued
+	dedupResolveTimeout = 10 * time.Second
+)
+
+// buildIdentity is what makes two build requests produce the same image.
+// The revision of git contexts is replaced by the commit it resolves to.
+type buildIdentity struct {
+	GitURL            string            `json:"git_url,omitempty"`
+	Commit            string            `json:"commit,omitempty"`
+	RecurseSubmodules bool              `json:"recurse_submodules,omitempty"`
+	ContextArchive    string            `json:"context_archive,omitempty"` // sha256 of the uploaded archive
+	ContextDir        string            `json:"context_dir,omitempty"`
+	DockerfilePath    string            `json:"dockerfile_path,omitempty"`
+	DockerfileContent string            `json:"dockerfile_content,omitempty"`
+	Target            string            `json:"target,omitempty"`
+	BuildArgs         []string          `json:"build_args,omitempty"`
+	Secrets           map[string]string `json:"secrets,omitempty"`
+	Platforms         []string          `json:"platforms,omitempty"`
+	Image             ImageOptions      `json:"image"`
+	Output            string            `json:"output,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Cache             CacheOptions      `json:"cache"`
+}
+
+// deduplicate returns the result of an identical build which is pending,
+// running or succeeded within the dedup window, if any. Otherwise it records
+// the request as the build of its identity in opts.DedupKey, and pins its
+// git revision to the commit of the identity.
+// nameGenerated tells if the image name was generated rather than requested,
+// so it does not tell builds apart.
+func (b *Builder) deduplicate(opts *BuilderOptions, nameGenerated bool) (BuildResult, bool, error) {
+	key, commit, err := b.dedupKey(*opts, nameGenerated)
+	if err != nil {
+		// The build still runs, it is only not deduplicated
+		b.logger.Warn("build is not deduplicated", zap.String("image_name", opts.Image.Name), zap.Error(err))
+		return BuildResult{}, false, nil
+	}
+	if key == "" {
+		return BuildResult{}, false, nil
+	}
+
+	// The key lives as long as the build status until the build finishes
+	res := BuildResult{ImageName: opts.Image.Name, ImageTag: opts.Image.Tag}
+	value, err := json.Marshal(res)
+	if err != nil {
+		return BuildResult{}, false, err
+	}
+
+	var (
+		existing BuildResult
+		found    bool
+	)
+	txf := func(tx *redis.Tx) error {
+		found = false
+		current, err := tx.Get(key).Bytes()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("getting identical build: %w", err)
+		}
+		if err == nil {
+			existing, found, err = b.existingBuild(current)
+			if err != nil || found {
+				return err
+			}
+		}
+
+		// There is no identical build, or it failed or is gone: this one
+		// replaces it
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			return pipe.Set(key, value, defaultRedisMsgTTL).Err()
+		})
+		return err
+	}
+
+	for i := 0; i < maxStatusUpdateRetries; i++ {
+		err := b.redisClient.Watch(txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return BuildResult{}, false, fmt.Errorf("deduplicating build: %w", err)
+		}
+		if found {
+			return existing, true, nil
+		}
+
+		// The build must be the one of its identity, even if the branch,
+		// tag or ref moves before it runs
+		if commit != "" {
+			opts.Git.Commit = commit
+		}
+		opts.DedupKey = key
+		return BuildResult{}, false, nil
+	}
+	return BuildResult{}, false, errors.New("deduplicating build: too many concurrent requests")
+}
+
+// existingBuild returns the build recorded by the value of a dedup key, if
+// it is pending, running or succeeded
+func (b *Builder) existingBuild(value []byte) (BuildResult, bool, error) {
+	var res BuildResult
+	if err := json.Unmarshal(value, &res); err != nil {
+		return BuildResult{}, false, fmt.Errorf("decoding identical build: %w", err)
+	}
+
+	status, err := b.GetBuildStatus(res.ImageName)
+	if err == ErrBuildNotFound {
+		return BuildResult{}, false, nil
+	}
+	if err != nil {
+		return BuildResult{}, false, err
+	}
+	switch status.Status {
+	case StatusPending, StatusBuilding, StatusSucceeded:
+		res.Deduplicated = true
+		return res, true, nil
+	}
+	return BuildResult{}, false, nil
+}
+
+// dedupKey returns the key of the identity of a validated build request and
+// the commit of its git context, or an empty key for the contexts whose
+// content cannot be known in advance. The identity is hashed with the
+// secrets key, so the key does not leak the secrets of the build.
+func (b *Builder) dedupKey(opts BuilderOptions, nameGenerated bool) (string, string, error) {
+	if opts.Context.URL != "" || opts.Context.LocalDir != "" {
+		return "", "", nil
+	}
+
+	id := buildIdentity{
+		ContextDir:        opts.ContextDir,
+		DockerfilePath:    opts.DockerfilePath,
+		DockerfileContent: opts.DockerfileContent,
+		Target:            opts.Target,
+		BuildArgs:         append([]string(nil), opts.BuildArgs...),
+		Secrets:           opts.Secrets,
+		Platforms:         append([]string(nil), opts.Platforms...),
+		Image:             opts.Image,
+		Output:            opts.Output,
+		Labels:            opts.Labels,
+		Cache:             opts.Cache,
+	}
+	sort.Strings(id.BuildArgs)
+	sort.Strings(id.Platforms)
+	if nameGenerated {
+		id.Image.Name = ""
+	}
+
+	if opts.Context.Uploaded {
+		sum := sha256.Sum256(opts.ContextArchive)
+		id.ContextArchive = hex.EncodeToString(sum[:])
+	} else {
+		var cred credentials.Credential
+		if opts.Git.Credentials != "" {
+			var err error
+			cred, err = b.credentials.Get(opts.Git.Credentials)
+			if err != nil {
+				return "", "", err
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dedupResolveTimeout)
+		defer cancel()
+		commit, err := resolveGitRevision(ctx, opts.Git, cred)
+		if err != nil {
+			return "", "", fmt.Errorf("resolving git revision: %w", err)
+		}
+		id.GitURL = opts.Git.URL
+		id.Commit = commit
+		id.RecurseSubmodules = opts.Git.RecurseSubmodules
+	}
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		return "", "", err
+	}
+	mac := hmac.New(sha256.New, b.secretsKey)
+	mac.Write(data)
+	return dedupKeyPrefix + hex.EncodeToString(mac.Sum(nil)), id.Commit, nil
+}
+
+// finishDedup keeps a succeeded build as the build of its identity for the
+// dedup window after it finished, and forgets the other builds
+func (b *Builder) finishDedup(key string, status BuildStatus) error {
+	if status == StatusSucceeded {
+		return b.redisClient.Expire(key, b.dedupWindow).Err()
+	}
+	return b.redisClient.Del(key).Err()
+}
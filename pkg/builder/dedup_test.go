@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDedup(t *testing.T) {
+	b, _ := newTestBuilder(t, WithDedupWindow(time.Hour))
+	commit := strings.Repeat("a", 40)
+	newOpts := func() BuilderOptions {
+		return BuilderOptions{
+			Git:       GitOptions{URL: "github.com/test-username/test-repo", Commit: commit},
+			BuildArgs: []string{"B=2", "A=1"},
+			Secrets:   map[string]string{"TOKEN": "secret"},
+		}
+	}
+
+	first, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.False(t, first.Deduplicated)
+
+	opts := newOpts()
+	opts.BuildArgs = []string{"A=1", "B=2"}
+	second, err := b.AddToBuildQueue(opts)
+	require.NoError(t, err)
+	assert.True(t, second.Deduplicated, "An identical pending build should be reused")
+	assert.Equal(t, first.ImageName, second.ImageName)
+	assert.Equal(t, first.ImageTag, second.ImageTag)
+
+	keys, err := b.redisClient.Keys(dedupKeyPrefix + "*").Result()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotContains(t, keys[0], "secret")
+
+	for _, modify := range []func(*BuilderOptions){
+		func(o *BuilderOptions) { o.BuildArgs = []string{"A=1", "B=3"} },
+		func(o *BuilderOptions) { o.Secrets["TOKEN"] = "other" },
+		func(o *BuilderOptions) { o.Git.Commit = strings.Repeat("b", 40) },
+		func(o *BuilderOptions) { o.Image.Name = "named" },
+		func(o *BuilderOptions) { o.NoDedup = true },
+	} {
+		opts := newOpts()
+		modify(&opts)
+		res, err := b.AddToBuildQueue(opts)
+		require.NoError(t, err)
+		assert.False(t, res.Deduplicated, "%+v should not be deduplicated", opts)
+	}
+
+	size, err := b.redisClient.LLen("build_queue:owner:github.com/test-username/test-repo").Result()
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, size)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.NotEmpty(t, bOpts.DedupKey)
+	b.runBuild(context.Background(), bOpts)
+	ttl, err := b.redisClient.TTL(bOpts.DedupKey).Result()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, time.Hour, "A succeeded build should be reused within the window")
+
+	third, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.True(t, third.Deduplicated, "A succeeded build should be reused")
+
+	require.NoError(t, b.UpdateBuildStatus(first.ImageName, BuildStatusData{Status: StatusFailed}))
+	fourth, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.False(t, fourth.Deduplicated, "A failed build should not be reused")
+	assert.NotEqual(t, first.ImageName, fourth.ImageName)
+
+	fifth, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.Equal(t, fourth.ImageName, fifth.ImageName, "The new build should replace the failed one")
+}
+
+func TestBuildDedupBranch(t *testing.T) {
+	b, kaniko := newTestBuilder(t, WithDedupWindow(time.Hour))
+	url := serveTestGitRepo(t, kaniko.contextDir)
+	newOpts := func() BuilderOptions {
+		return BuilderOptions{Git: GitOptions{URL: url, Branch: "master"}}
+	}
+
+	first, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.False(t, first.Deduplicated)
+
+	second, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.True(t, second.Deduplicated, "A public branch should be resolved without credentials")
+	assert.Equal(t, first.ImageName, second.ImageName)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.Equal(t, kaniko.commit, bOpts.Git.Commit, "The build should be pinned to the resolved commit")
+
+	repo, err := git.PlainOpen(kaniko.contextDir)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Commit("Move master", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	third, err := b.AddToBuildQueue(newOpts())
+	require.NoError(t, err)
+	assert.False(t, third.Deduplicated, "A moved branch should not be deduplicated")
+}
@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDockerfileContentSize bounds the inline Dockerfiles, which are
+	// queued along the build request
+	MaxDockerfileContentSize = 64 << 10
+
+	// inlineDockerfileName is the name inline Dockerfiles are written under
+	// in the root of the build context. It replaces any file with that name.
+	inlineDockerfileName = ".dockwiz.Dockerfile"
+)
+
+// targetRegex matches the Dockerfile stage names
+var targetRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// validateDockerfileContent checks an inline Dockerfile. It is not a full
+// parse, it only catches the content which cannot be a Dockerfile at all.
+func validateDockerfileContent(content string) error {
+	if len(content) > MaxDockerfileContentSize {
+		return fmt.Errorf("dockerfile content exceeds %d bytes", MaxDockerfileContentSize)
+	}
+	if !utf8.ValidString(content) || strings.ContainsRune(content, 0) {
+		return errors.New("dockerfile content must be valid UTF-8 text")
+	}
+
+	// The first instruction must be FROM, or ARG declaring the values
+	// used by the FROM instructions
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 4096), MaxDockerfileContentSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		instruction := strings.ToUpper(strings.Fields(line)[0])
+		if instruction == "FROM" {
+			return nil
+		}
+		if instruction != "ARG" {
+			return fmt.Errorf("dockerfile content must start with a FROM instruction, got %s", instruction)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("dockerfile content has no FROM instruction")
+}
+
+// writeInlineDockerfile writes an inline Dockerfile into the build context
+// and returns its path
+func writeInlineDockerfile(contextDir, content string) (string, error) {
+	dockerfilePath := filepath.Join(contextDir, inlineDockerfileName)
+	if err := os.WriteFile(dockerfilePath, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("writing inline dockerfile: %w", err)
+	}
+	return dockerfilePath, nil
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDockerfileContent(t *testing.T) {
+	testCases := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{name: "from", content: "FROM alpine\nRUN echo hi\n"},
+		{name: "comments and args first", content: "# syntax=docker/dockerfile:1\n\nARG VERSION=3.19\nfrom alpine:${VERSION}\n"},
+		{name: "empty", content: "  \n", expectErr: true},
+		{name: "no from", content: "ARG VERSION\n", expectErr: true},
+		{name: "run first", content: "RUN echo hi\nFROM alpine\n", expectErr: true},
+		{name: "binary", content: "FROM alpine\n\x00", expectErr: true},
+		{name: "too large", content: "FROM alpine\n" + strings.Repeat("#", MaxDockerfileContentSize), expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateDockerfileContent(tc.content)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildInlineDockerfile(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		DockerfilePath:    "Dockerfile",
+		DockerfileContent: "FROM alpine\n",
+		Git:               GitOptions{URL: "github.com/test-username/test-repo"},
+	})
+	assert.Error(t, err, "Dockerfile path and content should be exclusive")
+
+	res, err := b.AddToBuildQueue(BuilderOptions{
+		DockerfileContent: "FROM alpine\nRUN echo inline\n",
+		Git:               GitOptions{URL: "github.com/test-username/test-repo"},
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+
+	require.Len(t, kaniko.builds, 1)
+	dockerfilePath := filepath.Join(kaniko.contextDir, inlineDockerfileName)
+	assert.Equal(t, dockerfilePath, kaniko.builds[0].DockerfilePath, "The inline Dockerfile should be built")
+	content, err := os.ReadFile(dockerfilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "FROM alpine\nRUN echo inline\n", string(content))
+	assert.NotEmpty(t, res.ImageName)
+}
@@ -0,0 +1,329 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	kConfig "github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	gitContextPrefix = "git://"
+
+	// defaultGitSSHUser is the user of SSH clones when the credential has none
+	defaultGitSSHUser = "git"
+	// defaultGitTokenUser is sent along tokens, which most git hosts accept
+	// with any username
+	defaultGitTokenUser = "x-access-token"
+)
+
+// validateGitOptions checks the git options of a request and defaults the
+// branch when no other revision is requested
+func validateGitOptions(opts *GitOptions) error {
+	revisions := 0
+	for _, r := range []string{opts.Branch, opts.Tag, opts.Ref} {
+		if r != "" {
+			revisions++
+		}
+	}
+	if revisions > 1 {
+		return errors.New("only one of git branch, tag and ref can be given")
+	}
+
+	if opts.Ref != "" && !strings.HasPrefix(opts.Ref, "refs/") {
+		return fmt.Errorf("git ref %q must start with refs/", opts.Ref)
+	}
+
+	if opts.Commit != "" && !plumbing.IsHash(opts.Commit) {
+		return fmt.Errorf("git commit %q must be a full SHA", opts.Commit)
+	}
+
+	if revisions == 0 && opts.Commit == "" {
+		opts.Branch = defaultGitBranch
+	}
+	return nil
+}
+
+// gitSrcContext returns the Kaniko git context of the requested revision,
+// in the `git://url#ref#commit` format
+func gitSrcContext(opts GitOptions) string {
+	ref := opts.Ref
+	if opts.Tag != "" {
+		ref = plumbing.NewTagReferenceName(opts.Tag).String()
+	}
+
+	fragments := []string{}
+	if ref != "" {
+		fragments = append(fragments, ref)
+	}
+	if opts.Commit != "" {
+		fragments = append(fragments, opts.Commit)
+	}
+
+	if len(fragments) == 0 {
+		return gitContextPrefix + opts.URL
+	}
+	return gitContextPrefix + opts.URL + "#" + strings.Join(fragments, "#")
+}
+
+// resolveGitCommit returns the SHA of the commit checked out in a clone
+func resolveGitCommit(dir string) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+// authGitContext clones a git build context with a stored credential. It
+// follows the `url#ref#commit` format of the Kaniko git context, which can
+// only read HTTPS credentials from the environment of the whole process.
+type authGitContext struct {
+	context string // context without the git:// prefix
+	opts    buildcontext.BuildOptions
+	cred    credentials.Credential
+}
+
+func newAuthGitContext(srcContext string, opts buildcontext.BuildOptions, cred credentials.Credential) (*authGitContext, error) {
+	if !strings.HasPrefix(srcContext, gitContextPrefix) {
+		return nil, errors.New("credentials are only supported for git build contexts")
+	}
+	return &authGitContext{
+		context: strings.TrimPrefix(srcContext, gitContextPrefix),
+		opts:    opts,
+		cred:    cred,
+	}, nil
+}
+
+func (g *authGitContext) UnpackTarFromBuildContext() (string, error) {
+	directory := kConfig.BuildContextDir
+	parts := strings.Split(g.context, "#")
+
+	auth, cleanup, err := gitAuth(g.cred)
+	if err != nil {
+		return directory, err
+	}
+	defer cleanup()
+
+	url := gitCloneURL(parts[0], g.cred)
+	options := git.CloneOptions{
+		URL:               url,
+		Auth:              auth,
+		Progress:          os.Stdout,
+		SingleBranch:      g.opts.GitSingleBranch,
+		RecurseSubmodules: git.NoRecurseSubmodules,
+	}
+	if g.opts.GitRecurseSubmodules {
+		options.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+
+	var fetchRef, checkoutRef string
+	if len(parts) > 1 {
+		if plumbing.IsHash(parts[1]) {
+			fetchRef = parts[1]
+			checkoutRef = parts[1]
+		} else {
+			// Pull request refs are cloned directly, other refs are fetched
+			// after the clone
+			if !strings.HasPrefix(parts[1], "refs/pull/") {
+				fetchRef = parts[1]
+			}
+			options.ReferenceName = plumbing.ReferenceName(parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		checkoutRef = parts[2]
+	}
+
+	if g.opts.GitBranch != "" {
+		ref, err := gitBranchReference(url, auth, g.opts.GitBranch)
+		if err != nil {
+			return directory, err
+		}
+		options.ReferenceName = ref
+	}
+
+	logrus.Debugf("Getting source from reference %s", options.ReferenceName)
+	repo, err := git.PlainClone(directory, false, &options)
+	if err != nil {
+		return directory, err
+	}
+
+	if fetchRef != "" {
+		err = repo.Fetch(&git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			Auth:       auth,
+			RefSpecs:   []config.RefSpec{config.RefSpec(fetchRef + ":" + fetchRef)},
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return directory, err
+		}
+	}
+
+	if checkoutRef != "" {
+		wt, err := repo.Worktree()
+		if err != nil {
+			return directory, err
+		}
+		if err := wt.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(checkoutRef)}); err != nil {
+			return directory, err
+		}
+	}
+	return directory, nil
+}
+
+// gitBranchReference returns the reference of a branch, or of a tag with the
+// same name, like Kaniko does for its `--git branch=` option
+func gitBranchReference(url string, auth transport.AuthMethod, branch string) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(branch),
+		plumbing.NewTagReferenceName(branch),
+	} {
+		for _, ref := range refs {
+			if ref.Name() == name {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("invalid branch: %s", branch)
+}
+
+// gitCloneURL returns the URL a repository is cloned from with a credential
+func gitCloneURL(url string, cred credentials.Credential) string {
+	if cred.SSHPrivateKey != "" {
+		return "ssh://" + url
+	}
+	return "https://" + url
+}
+
+// gitAuth returns the git authentication of a credential, and a function
+// removing the temporary files it needs. An empty credential, as used for
+// public repositories, has no authentication
+func gitAuth(cred credentials.Credential) (transport.AuthMethod, func(), error) {
+	cleanup := func() {}
+
+	switch {
+	case cred.SSHPrivateKey != "":
+		user := cred.Username
+		if user == "" {
+			user = defaultGitSSHUser
+		}
+		auth, err := ssh.NewPublicKeys(user, []byte(cred.SSHPrivateKey), cred.Password)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("parsing ssh private key: %w", err)
+		}
+		// Without known hosts in the credential, the known_hosts files of
+		// the system are used
+		if cred.SSHKnownHosts == "" {
+			return auth, cleanup, nil
+		}
+
+		f, err := os.CreateTemp("", "known_hosts-*")
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { os.Remove(f.Name()) }
+		_, err = f.WriteString(cred.SSHKnownHosts)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, cleanup, err
+		}
+		auth.HostKeyCallback, err = ssh.NewKnownHostsCallback(f.Name())
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("parsing ssh known hosts: %w", err)
+		}
+		return auth, cleanup, nil
+
+	case cred.Token != "":
+		user := cred.Username
+		if user == "" {
+			user = defaultGitTokenUser
+		}
+		return &http.BasicAuth{Username: user, Password: cred.Token}, cleanup, nil
+
+	case cred.Username != "" || cred.Password != "":
+		return &http.BasicAuth{Username: cred.Username, Password: cred.Password}, cleanup, nil
+	}
+	return nil, cleanup, nil
+}
+
+// resolveGitRevision returns the commit the requested revision of a
+// repository points to, without cloning it
+func resolveGitRevision(ctx context.Context, opts GitOptions, cred credentials.Credential) (string, error) {
+	if opts.Commit != "" {
+		return opts.Commit, nil
+	}
+
+	auth, cleanup, err := gitAuth(cred)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gitCloneURL(opts.URL, cred)},
+	})
+	// Annotated tags point to a tag object, their peeled reference points
+	// to the commit
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, PeelingOption: git.AppendPeeled})
+	if err != nil {
+		return "", err
+	}
+	hashes := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range refs {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	var names []plumbing.ReferenceName
+	switch {
+	case opts.Branch != "":
+		names = []plumbing.ReferenceName{
+			plumbing.NewBranchReferenceName(opts.Branch),
+			plumbing.NewTagReferenceName(opts.Branch),
+		}
+	case opts.Tag != "":
+		names = []plumbing.ReferenceName{plumbing.NewTagReferenceName(opts.Tag)}
+	default:
+		names = []plumbing.ReferenceName{plumbing.ReferenceName(opts.Ref)}
+	}
+
+	for _, name := range names {
+		if hash, ok := hashes[name+"^{}"]; ok {
+			return hash.String(), nil
+		}
+		if hash, ok := hashes[name]; ok {
+			return hash.String(), nil
+		}
+	}
+	return "", fmt.Errorf("git revision %s not found", names[0])
+}
@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGitOptions(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	testCases := []struct {
+		name      string
+		opts      GitOptions
+		expected  GitOptions
+		expectErr bool
+	}{
+		{name: "default branch", opts: GitOptions{}, expected: GitOptions{Branch: defaultGitBranch}},
+		{name: "branch", opts: GitOptions{Branch: "dev"}, expected: GitOptions{Branch: "dev"}},
+		{name: "commit only", opts: GitOptions{Commit: sha}, expected: GitOptions{Commit: sha}},
+		{name: "tag and commit", opts: GitOptions{Tag: "v1.0.0", Commit: sha}, expected: GitOptions{Tag: "v1.0.0", Commit: sha}},
+		{name: "pull request ref", opts: GitOptions{Ref: "refs/pull/123/head"}, expected: GitOptions{Ref: "refs/pull/123/head"}},
+		{name: "branch and tag", opts: GitOptions{Branch: "dev", Tag: "v1.0.0"}, expectErr: true},
+		{name: "ref without prefix", opts: GitOptions{Ref: "pull/123/head"}, expectErr: true},
+		{name: "short commit", opts: GitOptions{Commit: "0123456"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			err := validateGitOptions(&opts)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestGitSrcContext(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	url := "github.com/test-username/test-repo"
+
+	assert.Equal(t, "git://"+url, gitSrcContext(GitOptions{URL: url, Branch: "main"}))
+	assert.Equal(t, "git://"+url+"#"+sha, gitSrcContext(GitOptions{URL: url, Commit: sha}))
+	assert.Equal(t, "git://"+url+"#refs/tags/v1.0.0", gitSrcContext(GitOptions{URL: url, Tag: "v1.0.0"}))
+	assert.Equal(t, "git://"+url+"#refs/pull/123/head#"+sha, gitSrcContext(GitOptions{URL: url, Ref: "refs/pull/123/head", Commit: sha}))
+}
+
+func TestBuildRecordsCommit(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/test-repo", Tag: "v1.0.0"},
+		Image:          ImageOptions{Name: "pinned", Tag: "1h", Destination: defaultImageDestination},
+	}
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+	require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+
+	assert.Equal(t, []string{"git://github.com/test-username/test-repo#refs/tags/v1.0.0"}, kaniko.contexts)
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	assert.Equal(t, kaniko.commit, bd.Commit, "The resolved commit should be recorded")
+}
+
+func TestGitAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	auth, cleanup, err := gitAuth(credentials.Credential{Token: "token"})
+	require.NoError(t, err)
+	cleanup()
+	assert.Equal(t, &githttp.BasicAuth{Username: defaultGitTokenUser, Password: "token"}, auth)
+
+	auth, cleanup, err = gitAuth(credentials.Credential{Username: "bot", Password: "password"})
+	require.NoError(t, err)
+	cleanup()
+	assert.Equal(t, &githttp.BasicAuth{Username: "bot", Password: "password"}, auth)
+
+	auth, cleanup, err = gitAuth(credentials.Credential{
+		SSHPrivateKey: keyPEM,
+		SSHKnownHosts: "github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n",
+	})
+	require.NoError(t, err)
+	defer cleanup()
+	sshAuth, ok := auth.(*ssh.PublicKeys)
+	require.True(t, ok, "SSH keys should be used when the credential has one")
+	assert.Equal(t, defaultGitSSHUser, sshAuth.User)
+	assert.NotNil(t, sshAuth.HostKeyCallback, "Known hosts of the credential should be checked")
+
+	_, _, err = gitAuth(credentials.Credential{SSHPrivateKey: "not a key"})
+	assert.Error(t, err)
+	auth, cleanup, err = gitAuth(credentials.Credential{})
+	require.NoError(t, err, "Public repositories have no credential")
+	cleanup()
+	assert.Nil(t, auth)
+
+	assert.Equal(t, "ssh://github.com/org/repo", gitCloneURL("github.com/org/repo", credentials.Credential{SSHPrivateKey: keyPEM}))
+	assert.Equal(t, "https://github.com/org/repo", gitCloneURL("github.com/org/repo", credentials.Credential{Token: "token"}))
+
+	_, err = (&Kaniko{}).GetBuildContext("dir:///tmp", buildcontext.BuildOptions{}, &credentials.Credential{Token: "token"})
+	assert.Error(t, err, "Credentials should only be accepted for git contexts")
+}
+
+func TestBuildPrivateRepository(t *testing.T) {
+	store := credentials.NewStore(map[string]credentials.Credential{
+		"github": {Token: "git-token"},
+	})
+	b, kaniko := newTestBuilder(t, WithCredentials(store))
+	kaniko.onBuild = func() {
+		logrus.Info("cloned with git-token")
+	}
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git: GitOptions{URL: "https://github.com/test-username/private-repo", Credentials: "missing"},
+	})
+	assert.ErrorIs(t, err, credentials.ErrCredentialNotFound, "Unknown credentials should be rejected")
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/private-repo", Branch: "main", Credentials: "github"},
+		Image:          ImageOptions{Name: "private", Tag: "1h", Destination: defaultImageDestination},
+	}
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+	require.NoError(t, b.build(context.Background(), bOpts, b.buildRedactor(bOpts)))
+
+	require.Len(t, kaniko.gitCreds, 1)
+	assert.Equal(t, &credentials.Credential{Token: "git-token"}, kaniko.gitCreds[0], "The git credential should be passed to the clone")
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	assert.NotContains(t, bd.Logs, "git-token", "The git credential should be redacted from the logs")
+}
@@ -0,0 +1,66 @@
+package builder
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// imageStats returns the size of an image, i.e. its manifest, config and
+// compressed layers, and its number of layers
+func imageStats(image v1.Image) (int64, int, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return 0, 0, err
+	}
+	manifestSize, err := image.Size()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := manifestSize + manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, len(manifest.Layers), nil
+}
+
+// imageResult describes the pushed artifact: the image of single platform
+// builds, or the index of multi-platform builds, whose size and layers are
+// the sums over its platform images. The reference is the one of the first
+// destination the artifact was pushed to.
+func imageResult(image v1.Image, index v1.ImageIndex, platforms []PlatformResult, destinations []DestinationResult) (*ImageResult, error) {
+	result := &ImageResult{}
+	for _, p := range platforms {
+		result.Size += p.Size
+		result.Layers += p.Layers
+	}
+
+	var (
+		digest v1.Hash
+		err    error
+	)
+	if index != nil {
+		digest, err = index.Digest()
+		if err != nil {
+			return nil, err
+		}
+		indexSize, err := index.Size()
+		if err != nil {
+			return nil, err
+		}
+		result.Size += indexSize
+	} else {
+		digest, err = image.Digest()
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Digest = digest.String()
+
+	for _, d := range destinations {
+		if d.Pushed {
+			result.Reference = d.DigestReference
+			break
+		}
+	}
+	return result, nil
+}
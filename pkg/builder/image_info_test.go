@@ -0,0 +1,28 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageStats(t *testing.T) {
+	image, err := random.Image(1024, 3)
+	require.NoError(t, err)
+
+	size, layers, err := imageStats(image)
+	require.NoError(t, err)
+	assert.Equal(t, 3, layers)
+
+	manifest, err := image.Manifest()
+	require.NoError(t, err)
+	manifestSize, err := image.Size()
+	require.NoError(t, err)
+	expected := manifestSize + manifest.Config.Size
+	for _, l := range manifest.Layers {
+		expected += l.Size
+	}
+	assert.Equal(t, expected, size)
+}
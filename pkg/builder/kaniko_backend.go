@@ -0,0 +1,388 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+)
+
+// KanikoBackend builds images with Kaniko, in the server process or in child
+// processes of a kaniko executable. It is the default backend, configured by
+// the Kaniko options of the Builder (e.g. WithSubprocessExecutor).
+type KanikoBackend struct {
+	kaniko      KanikoInterface // used for in-process builds
+	executable  string          // when set, builds run in child processes of this executable
+	ignorePaths []string        // directories the builds must not see
+	secretsDir  string          // the secrets of the running build are written in here
+	credentials *credentials.Store
+	cacheRepo   string
+	cacheTTL    time.Duration
+	logger      *zap.Logger
+}
+
+var _ BuildBackend = &KanikoBackend{}
+
+// newKanikoBackend returns the Kaniko backend configured by the options of b
+func newKanikoBackend(b *Builder) *KanikoBackend {
+	return &KanikoBackend{
+		kaniko:      &Kaniko{},
+		executable:  b.kanikoExecutable,
+		ignorePaths: b.ignorePaths(),
+		secretsDir:  secretsDir,
+		credentials: b.credentials,
+		cacheRepo:   b.defaultCacheRepo,
+		cacheTTL:    b.cacheTTL,
+		logger:      b.logger,
+	}
+}
+
+// inProcess tells if the builds run in the server process
+func (k *KanikoBackend) inProcess() bool {
+	return k.executable == ""
+}
+
+func (k *KanikoBackend) Build(ctx context.Context, req BuildRequest, events BuildEvents) error {
+	bOpts := req.Options
+
+	// Catch Kaniko logs and report them
+	logsHook := NewCatchLogsHook()
+	logChan, stop := logsHook.StreamNewLogs()
+	logsDone := make(chan struct{})
+	defer func() {
+		stop()
+		<-logsDone
+	}()
+
+	go func() {
+		defer close(logsDone)
+		for newLogs := range logChan {
+			events.Log(newLogs)
+			if steps := parseCacheSteps(newLogs); len(steps) > 0 {
+				if err := events.Report(BuildOutput{CacheSteps: steps}); err != nil {
+					k.logger.Error("adding cache steps to the build status:", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	contextDir := path.Join(req.Workspace, "context")
+	kaniko, release := k.kanikoFor(ctx, req.Workspace, contextDir, logsHook)
+	defer release()
+
+	buildPlatforms, err := resolvePlatforms(bOpts.CustomPlatform, bOpts.Platforms)
+	if err != nil {
+		return err
+	}
+
+	var srcContext string
+	switch {
+	case req.ContextArchive != "":
+		srcContext = tarContextPrefix + req.ContextArchive
+	case req.ContextDir != "":
+		srcContext = dirContextPrefix + req.ContextDir
+	default:
+		srcContext = gitSrcContext(bOpts.Git)
+	}
+
+	cacheRepo := k.cacheRepoOf(bOpts.Cache)
+	kOpts := &config.KanikoOptions{
+		SrcContext: srcContext,
+		Git: config.KanikoGitOptions{
+			Branch:            bOpts.Git.Branch,
+			SingleBranch:      bOpts.Git.SingleBranch,
+			RecurseSubmodules: bOpts.Git.RecurseSubmodules,
+		},
+		Target:       bOpts.Target,
+		BuildArgs:    bOpts.BuildArgs,
+		SnapshotMode: "full",
+		Destinations: destinationRefs(bOpts.Image),
+		// Without a repository, Kaniko caches in the destination, which builds
+		// saved as artifacts must not push to
+		Cache:        !bOpts.Cache.Disabled && (bOpts.pushesToRegistry() || cacheRepo != ""),
+		CacheRepo:    cacheRepo,
+		CacheOptions: config.CacheOptions{CacheTTL: k.cacheTTL},
+		// Like the Kaniko executor defaults, RUN layers are cached but not COPY
+		CacheRunLayers: true,
+		// Each platform is built on a clean filesystem, and the files a child
+		// process unpacked must not outlive its build
+		Cleanup: len(buildPlatforms) > 1 || !k.inProcess(),
+	}
+
+	var gitCred *credentials.Credential
+	if bOpts.Git.Credentials != "" {
+		cred, err := k.credentials.Get(bOpts.Git.Credentials)
+		if err != nil {
+			return err
+		}
+		gitCred = &cred
+	}
+
+	ctxExec, err := kaniko.GetBuildContext(kOpts.SrcContext, buildcontext.BuildOptions{
+		GitBranch:            kOpts.Git.Branch,
+		GitSingleBranch:      kOpts.Git.SingleBranch,
+		GitRecurseSubmodules: kOpts.Git.RecurseSubmodules,
+	}, gitCred)
+	if err != nil {
+		return err
+	}
+
+	k.logger.Debug("Getting source context from", zap.String("src_context", kOpts.SrcContext))
+
+	kOpts.SrcContext, err = ctxExec.UnpackTarFromBuildContext()
+	if err != nil {
+		return err
+	}
+	// In-process steps cannot be interrupted, so the build stops between them
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k.logger.Debug("Updated source context", zap.String("src_context", kOpts.SrcContext))
+
+	var commit string
+	if bOpts.Git.URL != "" {
+		commit, err = resolveGitCommit(kOpts.SrcContext)
+		if err != nil {
+			return fmt.Errorf("resolving git commit: %w", err)
+		}
+		if err := events.Report(BuildOutput{Commit: commit}); err != nil {
+			return fmt.Errorf("updating build status: %w", err)
+		}
+		events.Log(fmt.Sprintf("Building commit %s\n", commit))
+	}
+
+	kOpts.SrcContext, err = resolveContextDir(kOpts.SrcContext, bOpts.ContextDir)
+	if err != nil {
+		return err
+	}
+
+	kOpts.Labels = kanikoLabels(bOpts.Labels, sourceLabels(bOpts, commit, time.Now()))
+
+	if bOpts.DockerfileContent != "" {
+		kOpts.DockerfilePath, err = writeInlineDockerfile(kOpts.SrcContext, bOpts.DockerfileContent)
+	} else {
+		kOpts.DockerfilePath, err = filepath.Abs(filepath.Join(kOpts.SrcContext, bOpts.DockerfilePath))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Unlike build args, the files of the secrets are never recorded in the
+	// image config or history
+	removeSecrets, err := writeSecretFiles(k.secretsDir, bOpts.Secrets)
+	if err != nil {
+		return err
+	}
+	defer removeSecrets()
+
+	var (
+		images  = make([]v1.Image, 0, len(buildPlatforms))
+		results = make([]PlatformResult, 0, len(buildPlatforms))
+	)
+	for _, platform := range buildPlatforms {
+		pOpts := *kOpts
+		pOpts.CustomPlatform = platform
+
+		k.logger.Debug("Building image", zap.String("image_name", bOpts.Image.Name), zap.String("platform", platform))
+		image, err := kaniko.DoBuild(&pOpts)
+		if err != nil {
+			return fmt.Errorf("error building image for platform %s: %w", platform, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		digest, err := image.Digest()
+		if err != nil {
+			return fmt.Errorf("getting image digest for platform %s: %w", platform, err)
+		}
+		size, layers, err := imageStats(image)
+		if err != nil {
+			return fmt.Errorf("getting image size for platform %s: %w", platform, err)
+		}
+		images = append(images, image)
+		results = append(results, PlatformResult{
+			Platform: platform,
+			Digest:   digest.String(),
+			Size:     size,
+			Layers:   layers,
+		})
+	}
+
+	if err := events.Report(BuildOutput{Platforms: results}); err != nil {
+		return fmt.Errorf("updating build status: %w", err)
+	}
+
+	var (
+		image v1.Image
+		index v1.ImageIndex
+	)
+	if len(images) == 1 {
+		image = images[0]
+	} else {
+		index, err = newImageIndex(buildPlatforms, images)
+		if err != nil {
+			return fmt.Errorf("assembling image index: %w", err)
+		}
+	}
+
+	if !bOpts.pushesToRegistry() {
+		artifact, err := saveArtifact(req.ArtifactPath, bOpts, req.Workspace, image, index)
+		if err != nil {
+			return fmt.Errorf("saving image artifact: %w", err)
+		}
+		saved, err := imageResult(image, index, results, nil)
+		if err != nil {
+			return fmt.Errorf("describing saved image: %w", err)
+		}
+		if err := events.Report(BuildOutput{Image: saved, Artifact: artifact}); err != nil {
+			return fmt.Errorf("updating build status: %w", err)
+		}
+		return nil
+	}
+
+	pushResults, pushErr := k.push(kaniko, bOpts, image, index)
+	pushed, err := imageResult(image, index, results, pushResults)
+	if err != nil {
+		return fmt.Errorf("describing pushed image: %w", err)
+	}
+	if pushed.Reference == "" {
+		// Nothing was pushed
+		pushed = nil
+	}
+	if err := events.Report(BuildOutput{Image: pushed, Destinations: pushResults}); err != nil {
+		return fmt.Errorf("updating build status: %w", err)
+	}
+	if pushErr != nil {
+		return fmt.Errorf("error pushing image: %w", pushErr)
+	}
+
+	return nil
+}
+
+// cacheRepoOf returns the cache repository of a build. If empty, Kaniko uses
+// the repository of the first destination.
+func (k *KanikoBackend) cacheRepoOf(opts CacheOptions) string {
+	if opts.Repo != "" {
+		return opts.Repo
+	}
+	return k.cacheRepo
+}
+
+// kanikoFor returns the Kaniko executor of a single build, and a function
+// to call once the build is done
+func (k *KanikoBackend) kanikoFor(ctx context.Context, workspace, contextDir string, logsHook *CatchLogsHook) (KanikoInterface, func()) {
+	if !k.inProcess() {
+		return &KanikoProcess{
+			Context:     ctx,
+			Executable:  k.executable,
+			IgnorePaths: k.ignorePaths,
+			Workspace:   workspace,
+			ContextDir:  contextDir,
+			Output:      logsHook,
+		}, func() {}
+	}
+
+	// Since Kaniko does not receive a logger, we need to add the hook to the global logrus logger
+	// Important: Right now using the global logger is the only way to catch the logs in-process,
+	//   which is why in-process builds cannot run concurrently
+	config.BuildContextDir = contextDir
+	logrus.AddHook(logsHook)
+	return k.kaniko, func() {
+		removeLogrusHook(logsHook)
+	}
+}
+
+// removeLogrusHook removes a hook from the global logrus logger
+func removeLogrusHook(hook logrus.Hook) {
+	hooks := make(logrus.LevelHooks)
+	for level, levelHooks := range logrus.StandardLogger().Hooks {
+		for _, h := range levelHooks {
+			if h != hook {
+				hooks[level] = append(hooks[level], h)
+			}
+		}
+	}
+	logrus.StandardLogger().ReplaceHooks(hooks)
+}
+
+// pushAuth returns the authenticator of the destination's stored credentials,
+// or nil to let the default keychain resolve them
+func (k *KanikoBackend) pushAuth(d Destination) (authn.Authenticator, error) {
+	if d.Credentials == "" {
+		return nil, nil
+	}
+
+	cred, err := k.credentials.Get(d.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: cred.Username,
+		Password: cred.Password,
+	}), nil
+}
+
+// push pushes the image, or the image index for multi-platform builds, to
+// every destination. A failing destination does not stop the others, so the
+// result of each push is returned.
+func (k *KanikoBackend) push(kaniko KanikoInterface, bOpts BuilderOptions, image v1.Image, index v1.ImageIndex) ([]DestinationResult, error) {
+	var (
+		digest v1.Hash
+		err    error
+	)
+	if index != nil {
+		digest, err = index.Digest()
+	} else {
+		digest, err = image.Digest()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting digest: %w", err)
+	}
+
+	destinations := bOpts.Image.destinations()
+	results := make([]DestinationResult, 0, len(destinations))
+	var (
+		errs   []error
+		failed []Destination
+	)
+	for _, d := range destinations {
+		ref := bOpts.Image.reference(d)
+		result := DestinationResult{Reference: ref}
+
+		auth, err := k.pushAuth(d)
+		if err == nil {
+			if index != nil {
+				err = kaniko.DoPushIndex(index, ref, auth)
+			} else {
+				err = kaniko.DoPush(image, ref, auth)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			failed = append(failed, d)
+			// Redacted and logged with the build status
+			result.Error = err.Error()
+		} else {
+			result.Pushed = true
+			result.Digest = digest.String()
+			result.DigestReference = bOpts.Image.digestReference(d, digest)
+		}
+		results = append(results, result)
+	}
+
+	if len(errs) > 0 {
+		return results, &pushError{errs: errs, failed: failed, destinations: len(destinations)}
+	}
+	return results, nil
+}
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/alicebob/miniredis"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-redis/redis"
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeKaniko records the calls made by the builder instead of building anything
+type fakeKaniko struct {
+	mu         sync.Mutex
+	builds     []config.KanikoOptions
+	pushed     []v1.Image
+	pushedIdxs []v1.ImageIndex
+	pushedTo   []string
+	pushAuths  []authn.Authenticator
+	pushErrors map[string]error // destination -> error
+	contexts   []string
+	gitCreds   []*credentials.Credential
+	contextDir string // git repository with a single commit
+	commit     string
+	onBuild    func() // called by DoBuild, e.g. to simulate a long build
+}
+
+var _ KanikoInterface = &fakeKaniko{}
+
+type fakeBuildContext struct {
+	dir string
+}
+
+func (c *fakeBuildContext) UnpackTarFromBuildContext() (string, error) {
+	return c.dir, nil
+}
+
+func (k *fakeKaniko) GetBuildContext(srcContext string, _ buildcontext.BuildOptions, gitCred *credentials.Credential) (buildcontext.BuildContext, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.contexts = append(k.contexts, srcContext)
+	k.gitCreds = append(k.gitCreds, gitCred)
+	return &fakeBuildContext{dir: k.contextDir}, nil
+}
+
+func (k *fakeKaniko) DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
+	if k.onBuild != nil {
+		k.onBuild()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.builds = append(k.builds, *opts)
+	return random.Image(64, 1)
+}
+
+func (k *fakeKaniko) DoPush(image v1.Image, destination string, auth authn.Authenticator) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.pushErrors[destination]; err != nil {
+		return err
+	}
+	k.pushed = append(k.pushed, image)
+	k.pushedTo = append(k.pushedTo, destination)
+	k.pushAuths = append(k.pushAuths, auth)
+	return nil
+}
+
+func (k *fakeKaniko) DoPushIndex(index v1.ImageIndex, destination string, auth authn.Authenticator) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.pushErrors[destination]; err != nil {
+		return err
+	}
+	k.pushedIdxs = append(k.pushedIdxs, index)
+	k.pushedTo = append(k.pushedTo, destination)
+	k.pushAuths = append(k.pushAuths, auth)
+	return nil
+}
+
+// newTestBuilder returns a builder backed by miniredis and a fake Kaniko
+func newTestBuilder(t *testing.T, opts ...Option) (*Builder, *fakeKaniko) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error should be nil when starting miniredis server")
+	t.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	contextDir, commit := newTestGitRepo(t)
+	kaniko := &fakeKaniko{contextDir: contextDir, commit: commit}
+	key, err := newSecretsKey()
+	require.NoError(t, err)
+	opts = append([]Option{WithWorkspaceRoot(t.TempDir()), WithArtifactsDir(t.TempDir()), WithSecretsKey(key)}, opts...)
+	b := NewBuilder(rdb, zap.NewNop(), opts...)
+	if kb, ok := b.backend.(*KanikoBackend); ok {
+		kb.kaniko = kaniko
+		kb.secretsDir = t.TempDir()
+	}
+	return b, kaniko
+}
+
+// newTestGitRepo creates a git repository with a single commit and returns
+// its directory and the SHA of the commit
+func newTestGitRepo(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("Dockerfile")
+	require.NoError(t, err)
+
+	hash, err := wt.Commit("Add Dockerfile", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return dir, hash.String()
+}
+
+// serveTestGitRepo serves a git repository over HTTPS with git http-backend
+// and returns its URL. go-git trusts the certificate of the server until the
+// end of the test
+func serveTestGitRepo(t *testing.T, dir string) string {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is required to serve a repository")
+	}
+
+	srv := httptest.NewTLSServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(dir),
+			"GIT_HTTP_EXPORT_ALL=1",
+		},
+	})
+	t.Cleanup(srv.Close)
+
+	client.InstallProtocol("https", githttp.NewClient(srv.Client()))
+	t.Cleanup(func() { client.InstallProtocol("https", githttp.DefaultClient) })
+
+	return srv.URL + "/" + filepath.Base(dir)
+}
@@ -0,0 +1,233 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/executor"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+const (
+	// KanikoProcessCommand is the command the executable of a KanikoProcess
+	// must serve by calling RunKanikoProcess
+	KanikoProcessCommand = "kaniko-executor"
+
+	kanikoProcessStepContext = "context"
+	kanikoProcessStepBuild   = "build"
+
+	// The image built by a child process is passed to the parent as a tarball,
+	// which requires a tag. It is never pushed under this name.
+	kanikoProcessImageTag = "dockwiz.local/build:latest"
+
+	// processWaitDelay bounds the wait for the output of a killed child
+	processWaitDelay = 5 * time.Second
+)
+
+// KanikoProcess runs the Kaniko steps of a single build in child processes,
+// so builds do not share the global state Kaniko relies on (the build context
+// directory and the logrus logger). The child processes still unpack the
+// images into the root filesystem, so builds must not run concurrently.
+type KanikoProcess struct {
+	Context     context.Context // kills the child processes when done
+	Executable  string          // binary serving KanikoProcessCommand, usually dockwiz itself
+	IgnorePaths []string        // directories of dockwiz kept out of the snapshots, e.g. the workspaces of all builds
+	Workspace   string          // directory owned by this build
+	ContextDir  string          // directory the build context is unpacked into
+	Output      io.Writer
+}
+
+var _ KanikoInterface = &KanikoProcess{}
+
+// kanikoProcessRequest is sent by the parent to a child process on its stdin
+type kanikoProcessRequest struct {
+	SrcContext    string                    `json:"src_context,omitempty"`
+	BuildOptions  buildcontext.BuildOptions `json:"build_options"`
+	GitCredential *credentials.Credential   `json:"git_credential,omitempty"`
+	ContextDir    string                    `json:"context_dir,omitempty"`
+	Options       *config.KanikoOptions     `json:"options,omitempty"`
+	IgnorePaths   []string                  `json:"ignore_paths,omitempty"`
+	OutputPath    string                    `json:"output_path"`
+}
+
+type kanikoProcessContextResult struct {
+	Dir string `json:"dir"`
+}
+
+type processBuildContext struct {
+	k          *KanikoProcess
+	srcContext string
+	opts       buildcontext.BuildOptions
+	gitCred    *credentials.Credential
+}
+
+// GetBuildContext passes gitCred to the child on its stdin, so it never shows
+// up in the environment or the arguments of the process
+func (k *KanikoProcess) GetBuildContext(srcContext string, opts buildcontext.BuildOptions, gitCred *credentials.Credential) (buildcontext.BuildContext, error) {
+	return &processBuildContext{k: k, srcContext: srcContext, opts: opts, gitCred: gitCred}, nil
+}
+
+func (c *processBuildContext) UnpackTarFromBuildContext() (string, error) {
+	resultPath := path.Join(c.k.Workspace, "context.json")
+	err := c.k.run(kanikoProcessStepContext, kanikoProcessRequest{
+		SrcContext:    c.srcContext,
+		BuildOptions:  c.opts,
+		GitCredential: c.gitCred,
+		ContextDir:    c.k.ContextDir,
+		OutputPath:    resultPath,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(resultPath)
+	if err != nil {
+		return "", fmt.Errorf("reading build context result: %w", err)
+	}
+
+	var result kanikoProcessContextResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("parsing build context result: %w", err)
+	}
+	return result.Dir, nil
+}
+
+func (k *KanikoProcess) DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
+	// The image is read lazily from the tarball, so every build of the
+	// workspace (e.g. one per platform) needs its own file
+	f, err := os.CreateTemp(k.Workspace, "image-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	imagePath := f.Name()
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	err = k.run(kanikoProcessStepBuild, kanikoProcessRequest{
+		Options:     opts,
+		IgnorePaths: k.IgnorePaths,
+		OutputPath:  imagePath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tarball.ImageFromPath(imagePath, nil)
+}
+
+// DoPush and DoPushIndex only talk to the registries, so they safely run in
+// the parent process. Their logs go to the output of the build, like the
+// ones of the child processes, as no hook catches the global logger.
+func (k *KanikoProcess) DoPush(image v1.Image, destination string, auth authn.Authenticator) error {
+	return (&Kaniko{Output: k.Output}).DoPush(image, destination, auth)
+}
+
+func (k *KanikoProcess) DoPushIndex(index v1.ImageIndex, destination string, auth authn.Authenticator) error {
+	return (&Kaniko{Output: k.Output}).DoPushIndex(index, destination, auth)
+}
+
+// run starts a child process for a step and waits for it to finish
+func (k *KanikoProcess) run(step string, req kanikoProcessRequest) error {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx := k.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cmd := exec.CommandContext(ctx, k.Executable, KanikoProcessCommand, step)
+	cmd.Dir = k.Workspace
+	// The child runs in its own process group, so the commands of the
+	// Dockerfile it started are killed along with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = processWaitDelay
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = k.Output
+	cmd.Stderr = k.Output
+	// Intermediate stages are kept in the build workspace, so concurrent
+	// builds do not overwrite each other's
+	cmd.Env = append(os.Environ(), "KANIKO_DIR="+path.Join(k.Workspace, "kaniko"))
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("kaniko %s process stopped: %w", step, ctxErr)
+		}
+		return fmt.Errorf("kaniko %s process: %w", step, err)
+	}
+	return nil
+}
+
+// RunKanikoProcess is the entry point of the child processes started by
+// KanikoProcess. It reads the request from `in` and runs a single step.
+func RunKanikoProcess(step string, in io.Reader) error {
+	var req kanikoProcessRequest
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		return fmt.Errorf("decoding request: %w", err)
+	}
+
+	switch step {
+	case kanikoProcessStepContext:
+		config.BuildContextDir = req.ContextDir
+
+		bc, err := (&Kaniko{}).GetBuildContext(req.SrcContext, req.BuildOptions, req.GitCredential)
+		if err != nil {
+			return err
+		}
+		dir, err := bc.UnpackTarFromBuildContext()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(kanikoProcessContextResult{Dir: dir})
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(req.OutputPath, data, 0600)
+
+	case kanikoProcessStepBuild:
+		if req.Options == nil {
+			return fmt.Errorf("kaniko options are required")
+		}
+
+		// KANIKO_DIR points into this build's workspace, the workspaces of
+		// the other builds and the artifacts must be kept out of the
+		// snapshots too
+		for _, p := range req.IgnorePaths {
+			util.AddToDefaultIgnoreList(util.IgnoreListEntry{Path: p})
+		}
+
+		image, err := executor.DoBuild(req.Options)
+		if err != nil {
+			return err
+		}
+
+		tag, err := name.NewTag(kanikoProcessImageTag)
+		if err != nil {
+			return err
+		}
+		return tarball.WriteToFile(req.OutputPath, tag, image)
+	}
+
+	return fmt.Errorf("unknown step %q", step)
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary act as the child process of KanikoProcess
+func TestMain(m *testing.M) {
+	if len(os.Args) > 2 && os.Args[1] == KanikoProcessCommand {
+		if err := RunKanikoProcess(os.Args[2], os.Stdin); err != nil {
+			_, _ = os.Stderr.WriteString(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestKanikoProcessBuildContext(t *testing.T) {
+	workspace := t.TempDir()
+	srcDir := t.TempDir()
+
+	var output bytes.Buffer
+	k := &KanikoProcess{
+		Executable: os.Args[0],
+		Workspace:  workspace,
+		ContextDir: path.Join(workspace, "context"),
+		Output:     &output,
+	}
+
+	bc, err := k.GetBuildContext("dir://"+srcDir, buildcontext.BuildOptions{}, nil)
+	require.NoError(t, err, "Error should be nil when getting the build context")
+
+	dir, err := bc.UnpackTarFromBuildContext()
+	require.NoError(t, err, "Error should be nil when unpacking the build context: %s", output.String())
+	assert.Equal(t, srcDir, dir, "The child process should report the context directory")
+
+	bc, err = k.GetBuildContext("unknown://"+srcDir, buildcontext.BuildOptions{}, nil)
+	require.NoError(t, err, "Error should be nil when getting the build context")
+
+	_, err = bc.UnpackTarFromBuildContext()
+	assert.Error(t, err, "A failing child process should return an error")
+	assert.Contains(t, output.String(), "unknown build context prefix", "The child output should be captured")
+}
+
+func TestKanikoProcessPushLogs(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(64, 1)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	k := &KanikoProcess{Output: &output}
+	require.NoError(t, k.DoPush(image, host+"/test/image:latest", authn.Anonymous))
+	assert.Contains(t, output.String(), "Pushing image to "+host+"/test/image:latest", "The push logs should reach the build output")
+}
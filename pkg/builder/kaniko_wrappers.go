@@ -1,23 +1,43 @@
 package builder
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/creds"
 	"github.com/GoogleContainerTools/kaniko/pkg/executor"
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
 )
 
 type KanikoInterface interface {
-	GetBuildContext(srcContext string, opts buildcontext.BuildOptions) (buildcontext.BuildContext, error)
+	// GetBuildContext clones git contexts with gitCred if it is not nil
+	GetBuildContext(srcContext string, opts buildcontext.BuildOptions, gitCred *credentials.Credential) (buildcontext.BuildContext, error)
 	DoBuild(opts *config.KanikoOptions) (v1.Image, error)
-	DoPush(image v1.Image, opts *config.KanikoOptions) error
+	// DoPush and DoPushIndex push to a single destination. If auth is nil,
+	// the credentials are resolved with the Kaniko keychain.
+	DoPush(image v1.Image, destination string, auth authn.Authenticator) error
+	DoPushIndex(index v1.ImageIndex, destination string, auth authn.Authenticator) error
 }
 
-type Kaniko struct{}
+// Kaniko runs the Kaniko steps in the current process. Its logs go to the
+// global logrus logger, or to Output if it is set.
+type Kaniko struct {
+	Output io.Writer
+}
 
 var _ KanikoInterface = &Kaniko{}
 
-func (k *Kaniko) GetBuildContext(srcContext string, opts buildcontext.BuildOptions) (buildcontext.BuildContext, error) {
+func (k *Kaniko) GetBuildContext(srcContext string, opts buildcontext.BuildOptions, gitCred *credentials.Credential) (buildcontext.BuildContext, error) {
+	if gitCred != nil {
+		return newAuthGitContext(srcContext, opts, *gitCred)
+	}
 	return buildcontext.GetBuildContext(srcContext, opts)
 }
 
@@ -25,6 +45,69 @@ func (k *Kaniko) DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 	return executor.DoBuild(opts)
 }
 
-func (k *Kaniko) DoPush(image v1.Image, opts *config.KanikoOptions) error {
-	return executor.DoPush(image, opts)
+// DoPush pushes an image. Kaniko's own push only supports the credentials of
+// its keychain, so this uses go-containerregistry directly.
+func (k *Kaniko) DoPush(image v1.Image, destination string, auth authn.Authenticator) error {
+	destRef, remoteOpts, err := pushTarget(destination, auth)
+	if err != nil {
+		return err
+	}
+
+	log := k.logger()
+	log.Infof("Pushing image to %s", destRef.String())
+	if err := remote.Write(destRef, image, remoteOpts...); err != nil {
+		return fmt.Errorf("failed to push to destination %s: %w", destRef, err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	log.Infof("Pushed %s", destRef.Context().Digest(digest.String()))
+	return nil
+}
+
+// DoPushIndex pushes a multi-platform image index with all its images
+func (k *Kaniko) DoPushIndex(index v1.ImageIndex, destination string, auth authn.Authenticator) error {
+	destRef, remoteOpts, err := pushTarget(destination, auth)
+	if err != nil {
+		return err
+	}
+
+	log := k.logger()
+	log.Infof("Pushing image index to %s", destRef.String())
+	if err := remote.WriteIndex(destRef, index, remoteOpts...); err != nil {
+		return fmt.Errorf("failed to push to destination %s: %w", destRef, err)
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		return err
+	}
+	log.Infof("Pushed %s", destRef.Context().Digest(digest.String()))
+	return nil
+}
+
+// logger returns the logger of the pushes. Without an output, the logs go
+// to the global logger, which catches the logs of in-process builds.
+func (k *Kaniko) logger() logrus.FieldLogger {
+	if k.Output == nil {
+		return logrus.StandardLogger()
+	}
+	logger := logrus.New()
+	logger.SetOutput(k.Output)
+	logger.SetFormatter(logrus.StandardLogger().Formatter)
+	return logger
+}
+
+func pushTarget(destination string, auth authn.Authenticator) (name.Tag, []remote.Option, error) {
+	destRef, err := name.NewTag(destination, name.WeakValidation)
+	if err != nil {
+		return name.Tag{}, nil, fmt.Errorf("getting tag for destination %s: %w", destination, err)
+	}
+
+	if auth == nil {
+		return destRef, []remote.Option{remote.WithAuthFromKeychain(creds.GetKeychain())}, nil
+	}
+	return destRef, []remote.Option{remote.WithAuth(auth)}, nil
 }
@@ -0,0 +1,95 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	labelSource   = "org.opencontainers.image.source"
+	labelRevision = "org.opencontainers.image.revision"
+	labelCreated  = "org.opencontainers.image.created"
+	// labelBuildID holds the image name dockwiz queued the build under, which
+	// is the id of its build status
+	labelBuildID = "org.celestia.dockwiz.build-id"
+
+	maxLabels         = 64
+	maxLabelValueSize = 4 << 10
+)
+
+var labelKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]*[a-zA-Z0-9])?$`)
+
+// reservedLabels are set by dockwiz on every image it builds
+var reservedLabels = map[string]bool{
+	labelSource:   true,
+	labelRevision: true,
+	labelCreated:  true,
+	labelBuildID:  true,
+}
+
+// validateLabels checks the user supplied labels
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d labels can be given", maxLabels)
+	}
+
+	for key, value := range labels {
+		if !labelKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if reservedLabels[key] {
+			return fmt.Errorf("label %q is set by dockwiz and cannot be overridden", key)
+		}
+		if len(value) > maxLabelValueSize {
+			return fmt.Errorf("value of label %q exceeds %d bytes", key, maxLabelValueSize)
+		}
+	}
+	return nil
+}
+
+// sourceLabels returns the labels linking an image to its build: the OCI
+// source, revision and creation time, and the dockwiz build id. The source
+// and revision are only known for git and URL contexts.
+func sourceLabels(bOpts BuilderOptions, commit string, created time.Time) map[string]string {
+	labels := map[string]string{
+		labelCreated: created.UTC().Format(time.RFC3339),
+		labelBuildID: bOpts.Image.Name,
+	}
+
+	switch {
+	case bOpts.Git.URL != "":
+		labels[labelSource] = "https://" + bOpts.Git.URL
+	case bOpts.Context.URL != "":
+		labels[labelSource] = bOpts.Context.URL
+	}
+
+	if commit != "" {
+		labels[labelRevision] = commit
+	}
+	return labels
+}
+
+// kanikoLabels merges the labels in the KEY=VALUE format of Kaniko, sorted
+// by key
+func kanikoLabels(labelSets ...map[string]string) []string {
+	merged := map[string]string{}
+	for _, labels := range labelSets {
+		for key, value := range labels {
+			merged[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, key+"="+merged[key])
+	}
+	return res
+}
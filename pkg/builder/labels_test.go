@@ -0,0 +1,42 @@
+package builder
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, validateLabels(nil))
+	assert.NoError(t, validateLabels(map[string]string{"com.example.team": "infra", "maintainer": "bot@example.com"}))
+	assert.Error(t, validateLabels(map[string]string{"bad key": "value"}))
+	assert.Error(t, validateLabels(map[string]string{"": "value"}))
+	assert.Error(t, validateLabels(map[string]string{labelRevision: "abc"}), "Reserved labels should be rejected")
+	assert.Error(t, validateLabels(map[string]string{"big": strings.Repeat("a", maxLabelValueSize+1)}))
+}
+
+func TestBuildLabels(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	res, err := b.AddToBuildQueue(BuilderOptions{
+		Git:    GitOptions{URL: "https://github.com/test-username/test-repo"},
+		Labels: map[string]string{"com.example.team": "infra"},
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	require.NoError(t, b.build(context.Background(), bOpts, newRedactor()))
+
+	require.Len(t, kaniko.builds, 1)
+	labels := kaniko.builds[0].Labels
+	require.Len(t, labels, 5)
+	assert.Equal(t, "com.example.team=infra", labels[0], "Labels should be sorted by key")
+	assert.Equal(t, labelBuildID+"="+res.ImageName, labels[1])
+	assert.Regexp(t, "^"+labelCreated+"=\\d{4}-\\d{2}-\\d{2}T", labels[2])
+	assert.Equal(t, labelRevision+"="+kaniko.commit, labels[3])
+	assert.Equal(t, labelSource+"=https://github.com/test-username/test-repo", labels[4])
+}
@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
+	"go.uber.org/zap"
+)
+
+// minVisibilityTimeout bounds how often the workers extend their lease
+const minVisibilityTimeout = 3 * time.Second
+
+// errLeaseLost stops a build whose lease expired, as it was requeued and may
+// already run on another worker
+var errLeaseLost = errors.New("build lease lost")
+
+// dequeue waits for the next build of the queue until ctx is done. With a
+// visibility timeout, the build is reserved by the worker until its lease is
+// acknowledged, and the lease is nil otherwise.
+func (b *Builder) dequeue(ctx context.Context, consumer string, bOpts *BuilderOptions) (*redisqueue.Lease, error) {
+	if b.visibilityTimeout <= 0 {
+		return nil, b.Queue.DequeueContext(ctx, bOpts)
+	}
+	return b.Queue.ReserveContext(ctx, consumer, b.visibilityTimeout, bOpts)
+}
+
+// keepLease extends the lease of a running build until ctx is done, and
+// cancels the build with errLeaseLost if the lease was lost
+func (b *Builder) keepLease(ctx context.Context, lease *redisqueue.Lease, imageName string, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(b.visibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := lease.Extend(b.visibilityTimeout)
+			if errors.Is(err, redisqueue.ErrLeaseLost) {
+				cancel(errLeaseLost)
+				return
+			}
+			if err != nil {
+				b.logger.Error("extending build lease", zap.String("image_name", imageName), zap.Error(err))
+			}
+		}
+	}
+}
+
+// reapBuilds returns the builds of the workers which stopped extending their
+// lease to the queue until ctx is done
+func (b *Builder) reapBuilds(ctx context.Context) {
+	ticker := time.NewTicker(b.visibilityTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := b.Queue.Reap(time.Now(), b.recordRequeue)
+			if err != nil {
+				b.logger.Error("requeueing builds of unresponsive workers", zap.Error(err))
+			}
+			if n > 0 {
+				b.logger.Info("requeued builds of unresponsive workers", zap.Int("builds", n))
+			}
+		}
+	}
+}
+
+// recordRequeue sets a build returned to the queue back to pending, so a
+// worker picks it again
+func (b *Builder) recordRequeue(data []byte) error {
+	var bOpts BuilderOptions
+	if err := json.Unmarshal(data, &bOpts); err != nil {
+		// Workers drop the builds they cannot decode
+		return nil
+	}
+
+	err := b.modifyBuildStatus(bOpts.Image.Name, func(bd *BuildStatusData) error {
+		// A finished build is skipped by the workers
+		if bd.Status != StatusBuilding {
+			return nil
+		}
+		bd.Status = StatusPending
+		bd.Requeues++
+		bd.Logs += fmt.Sprintf("Worker stopped responding, build requeued (requeue %d)\n", bd.Requeues)
+		return nil
+	})
+	if errors.Is(err, ErrBuildNotFound) {
+		return nil
+	}
+	return err
+}
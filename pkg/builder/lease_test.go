@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildRequeue(t *testing.T) {
+	b, _ := newTestBuilder(t, WithVisibilityTimeout(time.Minute))
+	res, err := b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: "github.com/test-username/test-repo"}})
+	require.NoError(t, err)
+
+	// The worker crashes once the build started
+	var bOpts BuilderOptions
+	_, err = b.dequeue(context.Background(), "crashed", &bOpts)
+	require.NoError(t, err)
+	require.NoError(t, b.UpdateBuildStatus(res.ImageName, BuildStatusData{Status: StatusBuilding}))
+
+	n, err := b.Queue.Reap(time.Now(), b.recordRequeue)
+	require.NoError(t, err)
+	assert.Zero(t, n, "Builds should not be requeued before the visibility timeout")
+
+	n, err = b.Queue.Reap(time.Now().Add(2*time.Minute), b.recordRequeue)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	bd, err := b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, bd.Status, "Requeued builds should be pending again")
+	assert.Equal(t, 1, bd.Requeues)
+	assert.Contains(t, bd.Logs, "build requeued")
+
+	lease, err := b.dequeue(context.Background(), "worker", &bOpts)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	b.runLeasedBuild(context.Background(), bOpts, lease)
+	bd, err = b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, bd.Status)
+
+	n, err = b.Queue.Reap(time.Now().Add(2*time.Minute), b.recordRequeue)
+	require.NoError(t, err)
+	assert.Zero(t, n, "Acknowledged builds should not be requeued")
+
+	// A worker which lost its lease leaves the status to the requeued build
+	require.NoError(t, b.SetBuildStatus("abandoned", BuildStatusData{Status: StatusBuilding}))
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errLeaseLost)
+	b.runBuild(ctx, BuilderOptions{Image: ImageOptions{Name: "abandoned"}})
+	bd, err = b.GetBuildStatus("abandoned")
+	require.NoError(t, err)
+	assert.Equal(t, StatusBuilding, bd.Status)
+}
@@ -0,0 +1,292 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	// maxLintDockerfileSize bounds the Dockerfiles read from a build context
+	// to lint them
+	maxLintDockerfileSize = 1 << 20
+
+	// lintCommitRef is the reference a commit is fetched into to lint it
+	lintCommitRef = "refs/heads/dockwiz-lint"
+
+	// preflightLintTimeout bounds the lint of the builds which request it,
+	// as it runs before they are queued
+	preflightLintTimeout = time.Minute
+)
+
+// LintError rejects a build whose Dockerfile has lint errors
+type LintError struct {
+	Violations []lint.Violation
+}
+
+func (e *LintError) Error() string {
+	for _, v := range e.Violations {
+		if v.Severity == lint.SeverityError {
+			return fmt.Sprintf("dockerfile has lint errors: %s", v.Message)
+		}
+	}
+	return "dockerfile has lint errors"
+}
+
+// Lint checks the Dockerfile of a build request, read from the inline
+// content or from the build context, without building it. The request is
+// validated like a build, except that an inline Dockerfile needs no build
+// context, but the Dockerfile of a context URL is not linted.
+func (b *Builder) Lint(ctx context.Context, opts BuilderOptions) ([]lint.Violation, error) {
+	if err := b.prepareRequest(&opts, true); err != nil {
+		return nil, err
+	}
+	return b.lintDockerfile(ctx, opts)
+}
+
+// preflightLint rejects a build request whose Dockerfile has lint errors
+func (b *Builder) preflightLint(opts BuilderOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightLintTimeout)
+	defer cancel()
+
+	violations, err := b.lintDockerfile(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("linting dockerfile: %w", err)
+	}
+	if lint.HasErrors(violations) {
+		return &LintError{Violations: violations}
+	}
+	return nil
+}
+
+func (b *Builder) lintDockerfile(ctx context.Context, opts BuilderOptions) ([]lint.Violation, error) {
+	dockerfile, err := b.readDockerfile(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lintOpts := lint.Options{Target: opts.Target}
+	for _, arg := range opts.BuildArgs {
+		lintOpts.BuildArgs = append(lintOpts.BuildArgs, strings.SplitN(arg, "=", 2)[0])
+	}
+	return b.linter.Lint(dockerfile, lintOpts), nil
+}
+
+// readDockerfile returns the Dockerfile of a validated build request
+func (b *Builder) readDockerfile(ctx context.Context, opts BuilderOptions) ([]byte, error) {
+	if opts.DockerfileContent != "" {
+		return []byte(opts.DockerfileContent), nil
+	}
+
+	// The Dockerfile path is relative to the context dir, and both are
+	// relative to the root of the build context
+	name := path.Join(opts.ContextDir, opts.DockerfilePath)
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("dockerfile path %q is outside the build context", opts.DockerfilePath)
+	}
+
+	switch {
+	case opts.Context.Uploaded:
+		return readArchiveFile(bytes.NewReader(opts.ContextArchive), name)
+
+	case opts.Context.URL != "":
+		return nil, errors.New("linting the dockerfile of a context url is not supported, use dockerfile_content")
+
+	case opts.Context.LocalDir != "":
+		dir, err := b.localContextDir(opts.Context.LocalDir)
+		if err != nil {
+			return nil, err
+		}
+		dockerfile, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("resolving dockerfile: %w", err)
+		}
+		if !isWithin(dir, dockerfile) {
+			return nil, fmt.Errorf("dockerfile path %q is outside the build context", opts.DockerfilePath)
+		}
+		return readFile(dockerfile)
+	}
+
+	var cred credentials.Credential
+	if opts.Git.Credentials != "" {
+		var err error
+		cred, err = b.credentials.Get(opts.Git.Credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return readGitFile(ctx, opts.Git, cred, name)
+}
+
+// readGitFile reads a file of the requested revision of a git repository,
+// fetched in memory without its history
+func readGitFile(ctx context.Context, opts GitOptions, cred credentials.Credential, name string) ([]byte, error) {
+	auth, cleanup, err := gitAuth(cred)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	repo, err := fetchGitRevision(ctx, gitCloneURL(opts.URL, cred), auth, opts)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("getting commit %s: %w", head.Hash(), err)
+	}
+	file, err := commit.File(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	if file.Size > maxLintDockerfileSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", name, maxLintDockerfileSize)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// fetchGitRevision fetches the requested revision of a git repository into
+// memory, and points HEAD to its commit. Only the commit is fetched, unless
+// the server cannot serve a commit by its hash.
+func fetchGitRevision(ctx context.Context, url string, auth transport.AuthMethod, opts GitOptions) (*git.Repository, error) {
+	if opts.Commit != "" {
+		repo, err := fetchGitCommit(ctx, url, auth, opts.Commit)
+		if !errors.Is(err, git.ErrExactSHA1NotSupported) {
+			return repo, err
+		}
+	}
+
+	options := git.CloneOptions{
+		URL:          url,
+		Auth:         auth,
+		SingleBranch: true,
+		NoCheckout:   true,
+	}
+	var err error
+	switch {
+	case opts.Branch != "":
+		options.ReferenceName, err = gitBranchReference(url, auth, opts.Branch)
+		if err != nil {
+			return nil, err
+		}
+	case opts.Tag != "":
+		options.ReferenceName = plumbing.NewTagReferenceName(opts.Tag)
+	case opts.Ref != "":
+		options.ReferenceName = plumbing.ReferenceName(opts.Ref)
+	}
+
+	// The history is only needed to find a commit
+	if opts.Commit == "" {
+		options.Depth = 1
+	} else if options.ReferenceName == "" {
+		options.SingleBranch = false
+	}
+
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &options)
+	if err != nil {
+		return nil, fmt.Errorf("cloning git repository: %w", err)
+	}
+	if opts.Commit != "" {
+		hash := plumbing.NewHash(opts.Commit)
+		if _, err := repo.CommitObject(hash); err != nil {
+			return nil, fmt.Errorf("getting commit %s: %w", hash, err)
+		}
+		ref := plumbing.NewHashReference(plumbing.HEAD, hash)
+		if err := repo.Storer.SetReference(ref); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// fetchGitCommit fetches a single commit of a git repository by its hash,
+// without its history
+func fetchGitCommit(ctx context.Context, url string, auth transport.AuthMethod, commit string) (*git.Repository, error) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		Auth:     auth,
+		Depth:    1,
+		RefSpecs: []config.RefSpec{config.RefSpec(commit + ":" + lintCommitRef)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching commit %s: %w", commit, err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.HEAD, plumbing.NewHash(commit))
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// readArchiveFile reads a file of a tar.gz archive
+func readArchiveFile(r io.Reader, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in the build context", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Clean(hdr.Name) != name {
+			continue
+		}
+		if hdr.Size > maxLintDockerfileSize {
+			return nil, fmt.Errorf("%s exceeds %d bytes", name, maxLintDockerfileSize)
+		}
+		return io.ReadAll(tr)
+	}
+}
+
+func readFile(name string) ([]byte, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxLintDockerfileSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", filepath.Base(name), maxLintDockerfileSize)
+	}
+	return os.ReadFile(name)
+}
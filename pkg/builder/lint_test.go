@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	root := t.TempDir()
+	service := filepath.Join(root, "service")
+	require.NoError(t, os.MkdirAll(filepath.Join(service, "api"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(service, "api", "Dockerfile"), []byte("FROM alpine\n"), 0644))
+	require.NoError(t, os.Symlink("/etc/hostname", filepath.Join(service, "Dockerfile")))
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	content := []byte("FROM alpine:3.19\nADD https://example.com/app /app\nUSER app\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./api/Dockerfile", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	b, _ := newTestBuilder(t, WithLocalContextRoot(root))
+
+	violations, err := b.Lint(context.Background(), BuilderOptions{DockerfileContent: "FROM alpine:3.19\nUSER app\n"})
+	require.NoError(t, err, "An inline Dockerfile should be linted without a build context")
+	assert.Empty(t, violations)
+
+	_, err = b.Lint(context.Background(), BuilderOptions{})
+	assert.Error(t, err, "A build context is required without an inline Dockerfile")
+
+	violations, err = b.Lint(context.Background(), BuilderOptions{ContextArchive: archive.Bytes(), ContextDir: "api"})
+	require.NoError(t, err)
+	require.Len(t, violations, 1, "%+v", violations)
+	assert.Equal(t, lint.RuleRemoteAdd, violations[0].Rule)
+
+	violations, err = b.Lint(context.Background(), BuilderOptions{Context: ContextOptions{LocalDir: service}, DockerfilePath: "api/Dockerfile"})
+	require.NoError(t, err)
+	require.Len(t, violations, 2, "%+v", violations)
+	assert.Equal(t, lint.RuleUnpinnedBaseImage, violations[0].Rule)
+	assert.Equal(t, lint.RuleMissingUser, violations[1].Rule)
+
+	_, err = b.Lint(context.Background(), BuilderOptions{Context: ContextOptions{LocalDir: service}})
+	assert.Error(t, err, "The Dockerfile should not be read outside the context")
+
+	_, err = b.Lint(context.Background(), BuilderOptions{Context: ContextOptions{URL: "https://example.com/context.tar.gz"}})
+	assert.Error(t, err, "Context urls are not linted")
+
+	_, err = b.Lint(context.Background(), BuilderOptions{ContextArchive: archive.Bytes()})
+	assert.Error(t, err, "A missing Dockerfile should fail")
+}
+
+func TestLintPublicRepo(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+	url := serveTestGitRepo(t, kaniko.contextDir)
+
+	violations, err := b.Lint(context.Background(), BuilderOptions{Git: GitOptions{URL: url, Branch: "master"}})
+	require.NoError(t, err, "A public repository should be read without credentials")
+	assert.False(t, lint.HasErrors(violations), "%+v", violations)
+
+	_, err = b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: url, Branch: "master"}, Lint: true})
+	assert.NoError(t, err, "The preflight lint should read a public repository")
+}
+
+func TestFetchGitRevision(t *testing.T) {
+	dir, commit := newTestGitRepo(t)
+
+	// The local transport cannot serve a commit by its hash, the repository
+	// is then cloned with its history
+	for _, opts := range []GitOptions{{}, {Commit: commit}, {Branch: "master", Commit: commit}} {
+		repo, err := fetchGitRevision(context.Background(), "file://"+dir, nil, opts)
+		require.NoError(t, err, "%+v", opts)
+		head, err := repo.Head()
+		require.NoError(t, err)
+		assert.Equal(t, commit, head.Hash().String(), "%+v", opts)
+	}
+
+	_, err := fetchGitRevision(context.Background(), "file://"+dir, nil, GitOptions{Commit: strings.Repeat("a", 40)})
+	assert.Error(t, err, "Unknown commits should fail")
+}
+
+func TestBuildLintPreflight(t *testing.T) {
+	b, _ := newTestBuilder(t)
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		DockerfileContent: "FROM ${BASE}\nUSER app\n",
+		Git:               GitOptions{URL: "github.com/test-username/test-repo"},
+		Lint:              true,
+	})
+	var lintErr *LintError
+	require.ErrorAs(t, err, &lintErr)
+	assert.Equal(t, lint.RuleUnknownArg, lintErr.Violations[0].Rule)
+
+	size, err := b.redisClient.LLen("build_queue:owner:github.com/test-username/test-repo").Result()
+	require.NoError(t, err)
+	assert.Zero(t, size, "A build failing the lint should not be queued")
+
+	_, err = b.AddToBuildQueue(BuilderOptions{
+		DockerfileContent: "FROM alpine\n",
+		Git:               GitOptions{URL: "github.com/test-username/test-repo"},
+		Lint:              true,
+	})
+	assert.NoError(t, err, "Warnings should not reject the build")
+}
@@ -2,6 +2,7 @@ package builder
 
 import (
 	"bytes"
+	"io"
 	"sync"
 	"time"
 
@@ -12,13 +13,18 @@ const (
 	logFetchInterval = 100 * time.Millisecond
 )
 
+// CatchLogsHook collects the logs of a build, either as a logrus hook for
+// in-process builds or as an io.Writer for the output of child processes
 type CatchLogsHook struct {
 	Logs         *bytes.Buffer
 	lastReadPos  int64 // Track the last read position
 	lastReadLock sync.Mutex
 }
 
-var _ logrus.Hook = (*CatchLogsHook)(nil)
+var (
+	_ logrus.Hook = (*CatchLogsHook)(nil)
+	_ io.Writer   = (*CatchLogsHook)(nil)
+)
 
 func NewCatchLogsHook() *CatchLogsHook {
 	return &CatchLogsHook{
@@ -48,28 +54,60 @@ func (h *CatchLogsHook) Fire(entry *logrus.Entry) error {
 	return nil
 }
 
-// StreamNewLogs continuously streams new logs since the last read position
+// Write appends raw output to the logs
+func (h *CatchLogsHook) Write(p []byte) (int, error) {
+	h.lastReadLock.Lock()
+	defer h.lastReadLock.Unlock()
+	return h.Logs.Write(p)
+}
+
+// StreamNewLogs continuously streams new logs since the last read position.
+// Only complete lines are streamed, so a line written in several chunks is
+// never split, until the returned stop function flushes the rest.
 func (h *CatchLogsHook) StreamNewLogs() (chan string, func()) {
-	logChan := make(chan string)
-	ticker := time.NewTicker(logFetchInterval)
+	var (
+		logChan = make(chan string)
+		ticker  = time.NewTicker(logFetchInterval)
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+	)
 
 	go func() {
-		for range ticker.C {
-			h.lastReadLock.Lock()
-			newLogs := h.Logs.Bytes()[h.lastReadPos:]
-			h.lastReadPos = int64(h.Logs.Len())
-			h.lastReadLock.Unlock()
-
-			if len(newLogs) > 0 {
-				logChan <- string(newLogs)
+		defer close(stopped)
+		defer close(logChan)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if newLogs := h.readNewLogs(false); newLogs != "" {
+					logChan <- newLogs
+				}
+			case <-done:
+				if newLogs := h.readNewLogs(true); newLogs != "" {
+					logChan <- newLogs
+				}
+				return
 			}
 		}
 	}()
 
 	return logChan, func() {
-		// Wait for the last logs to be streamed
-		time.Sleep(500 * time.Millisecond)
-		ticker.Stop()
-		close(logChan)
+		close(done)
+		<-stopped
+	}
+}
+
+// readNewLogs returns the logs written since the last read, up to the last
+// complete line unless all is set
+func (h *CatchLogsHook) readNewLogs(all bool) string {
+	h.lastReadLock.Lock()
+	defer h.lastReadLock.Unlock()
+
+	newLogs := h.Logs.Bytes()[h.lastReadPos:]
+	if !all {
+		newLogs = newLogs[:bytes.LastIndexByte(newLogs, '\n')+1]
 	}
+	h.lastReadPos += int64(len(newLogs))
+	return string(newLogs)
 }
@@ -42,3 +42,33 @@ func TestCatchLogsHook(t *testing.T) {
 	assert.Contains(t, logsBuffer, "New log message 1", "Log message 1 should be present")
 	assert.Contains(t, logsBuffer, "New log message 2", "Log message 2 should be present")
 }
+
+func TestCatchLogsHookWriter(t *testing.T) {
+	hook := builder.NewCatchLogsHook()
+	logChan, stop := hook.StreamNewLogs()
+
+	_, err := hook.Write([]byte("partial"))
+	assert.NoError(t, err, "Error should be nil when writing")
+
+	// Incomplete lines are not streamed
+	select {
+	case logs := <-logChan:
+		t.Fatalf("unexpected logs: %q", logs)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	_, err = hook.Write([]byte(" line\ntail"))
+	assert.NoError(t, err, "Error should be nil when writing")
+	assert.Equal(t, "partial line\n", <-logChan, "Complete lines should be streamed")
+
+	// Stopping flushes the rest
+	received := make(chan string)
+	go func() {
+		for logs := range logChan {
+			received <- logs
+		}
+		close(received)
+	}()
+	stop()
+	assert.Equal(t, "tail", <-received, "The rest should be flushed on stop")
+}
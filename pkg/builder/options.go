@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"strings"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+)
+
+// Option configures optional settings of a Builder
+type Option func(*Builder)
+
+// WithSecretsKey sets the key used to encrypt build secrets before they are
+// stored in the queue. All instances sharing a queue must use the same key.
+// Without a key, builds with secrets are rejected.
+func WithSecretsKey(key []byte) Option {
+	return func(b *Builder) {
+		b.secretsKey = key
+	}
+}
+
+// WithCredentials sets the store of the credentials the builds can refer to
+func WithCredentials(store *credentials.Store) Option {
+	return func(b *Builder) {
+		b.credentials = store
+	}
+}
+
+// WithBackend sets the backend the images are built with. By default they
+// are built with Kaniko (see KanikoBackend), and the Kaniko options of the
+// Builder only apply to the default backend.
+func WithBackend(backend BuildBackend) Option {
+	return func(b *Builder) {
+		b.backend = backend
+	}
+}
+
+// WithSubprocessExecutor runs every build in child processes of the given
+// executable, which must serve KanikoProcessCommand (see KanikoProcess)
+func WithSubprocessExecutor(executable string) Option {
+	return func(b *Builder) {
+		b.kanikoExecutable = executable
+	}
+}
+
+// WithWorkspaceRoot sets the directory the build workspaces are created in
+func WithWorkspaceRoot(dir string) Option {
+	return func(b *Builder) {
+		b.workspaceRoot = dir
+	}
+}
+
+// WithMaxBuildTimeout sets the longest time a build can run. It is also
+// the timeout of the builds which do not request one.
+func WithMaxBuildTimeout(timeout time.Duration) Option {
+	return func(b *Builder) {
+		b.maxBuildTimeout = timeout
+	}
+}
+
+// WithLocalContextRoot allows builds to use the directories under dir as
+// their build context. Only set it for trusted deployments, as any user of
+// the API can then build from these directories.
+func WithLocalContextRoot(dir string) Option {
+	return func(b *Builder) {
+		b.localContextRoot = dir
+	}
+}
+
+// WithContextURLAllowedHosts restricts the hosts the context archives of the
+// builds can be downloaded from, redirects included. Any host is allowed by
+// default, but loopback, private, link-local and multicast addresses are
+// always rejected.
+func WithContextURLAllowedHosts(hosts ...string) Option {
+	return func(b *Builder) {
+		b.contextURLAllowedHosts = nil
+		for _, h := range hosts {
+			b.contextURLAllowedHosts = append(b.contextURLAllowedHosts, strings.ToLower(h))
+		}
+	}
+}
+
+// WithCacheRepo sets the repository the layers are cached in, for the builds
+// which do not set their own. By default Kaniko uses the repository of the
+// first destination.
+func WithCacheRepo(repo string) Option {
+	return func(b *Builder) {
+		b.defaultCacheRepo = repo
+	}
+}
+
+// WithCacheTTL sets how long cached layers are used
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(b *Builder) {
+		b.cacheTTL = ttl
+	}
+}
+
+// WithArtifactsDir sets the directory the images of the builds which are not
+// pushed are saved in. Instances sharing a queue must share the directory to
+// serve the artifacts of each other's builds.
+func WithArtifactsDir(dir string) Option {
+	return func(b *Builder) {
+		b.artifactsDir = dir
+	}
+}
+
+// WithLinter sets the linter of the Dockerfiles, which uses
+// lint.DefaultRules by default
+func WithLinter(linter *lint.Linter) Option {
+	return func(b *Builder) {
+		b.linter = linter
+	}
+}
+
+// WithDedupWindow deduplicates the identical builds requested while one is
+// pending or running, or within the window after it succeeded. Builds are
+// not deduplicated by default.
+func WithDedupWindow(window time.Duration) Option {
+	return func(b *Builder) {
+		b.dedupWindow = window
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the builds which do not set their
+// own. Without it builds are not retried, while the serve command allows 3
+// attempts by default (see --retry-max-attempts).
+func WithRetryPolicy(maxAttempts int, backoff time.Duration) Option {
+	return func(b *Builder) {
+		b.retryMaxAttempts = maxAttempts
+		b.retryBackoff = backoff
+	}
+}
+
+// WithMaxDiskUsage pauses the workers while the filesystem of the build
+// workspaces is more than percent used. Without it there is no ceiling,
+// while the serve command pauses above 90% by default (see
+// --max-disk-usage).
+func WithMaxDiskUsage(percent float64) Option {
+	return func(b *Builder) {
+		b.maxDiskUsage = percent
+	}
+}
+
+// WithVisibilityTimeout makes the queue reliable: a worker reserves its
+// build instead of removing it from the queue, and keeps extending its lease
+// while it runs. The builds of a worker which did not extend its lease within
+// the timeout, e.g. because it crashed, are returned to the queue. Without
+// it the queue is not reliable, while the serve command uses a 2m timeout by
+// default (see --visibility-timeout).
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(b *Builder) {
+		b.visibilityTimeout = timeout
+	}
+}
+
+// WithOwnerLimits limits how many builds of an owner run at once, across
+// the instances sharing the redis, to limit, or to limits[owner] if set. 0
+// means no limit, which is the default. It requires a visibility timeout, as
+// the running builds are tracked by their lease.
+func WithOwnerLimits(limit int, limits map[string]int) Option {
+	return func(b *Builder) {
+		b.ownerLimit = limit
+		b.ownerLimits = limits
+	}
+}
+
+// WithStarvationLimit sets how many builds of higher priorities run in a row
+// while a lower priority build waits, before it runs. It defaults to
+// redisqueue.DefaultStarvationLimit.
+func WithStarvationLimit(n int) Option {
+	return func(b *Builder) {
+		b.starvationLimit = n
+	}
+}
@@ -0,0 +1,43 @@
+package builder
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxOwnerLength bounds the owner of a build, which is part of queue keys
+const maxOwnerLength = 256
+
+func validateOwner(owner string) error {
+	if len(owner) > maxOwnerLength {
+		return errors.New("owner is too long")
+	}
+	if strings.ContainsRune(owner, 0) {
+		return errors.New("owner must not contain NUL bytes")
+	}
+	return nil
+}
+
+// queueOwner returns the owner of a build, which groups its builds in the
+// queue: the workers take the builds of the owners in turn, and may limit
+// how many builds of an owner run at once. It is derived from the request,
+// so clients cannot spread their builds over made up owners: the stored git
+// credentials of the build if any, as they belong to a team, and its git
+// repository otherwise.
+func queueOwner(opts BuilderOptions) string {
+	if opts.Git.Credentials != "" {
+		return opts.Git.Credentials
+	}
+	return defaultOwner(opts)
+}
+
+// defaultOwner returns the owner of a build without git credentials: its git
+// repository, without credentials, so every repository gets its share of the
+// workers. Builds of uploaded or local contexts share the default owner "".
+func defaultOwner(opts BuilderOptions) string {
+	repo := opts.Git.URL
+	if at := strings.Index(repo, "@"); at >= 0 && !strings.Contains(repo[:at], "/") {
+		repo = repo[at+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+}
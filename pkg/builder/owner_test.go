@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildOwner(t *testing.T) {
+	store := credentials.NewStore(map[string]credentials.Credential{
+		"team": {Token: "ghp_test"},
+	})
+	b, _ := newTestBuilder(t, WithCredentials(store))
+	_, err := b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: "github.com/test-username/" + strings.Repeat("a", maxOwnerLength)}})
+	assert.Error(t, err, "Invalid owners should be rejected")
+
+	for _, build := range []struct{ name, repo, credentials string }{
+		{"a1", "github.com/test-username/repo-a", ""},
+		{"a2", "github.com/test-username/repo-a", ""},
+		{"a3", "github.com/test-username/repo-a", ""},
+		{"b1", "github.com/test-username/repo-b", ""},
+		{"team1", "github.com/test-username/repo-a", "team"},
+	} {
+		_, err := b.AddToBuildQueue(BuilderOptions{
+			Git:   GitOptions{URL: build.repo, Credentials: build.credentials},
+			Image: ImageOptions{Name: build.name},
+		})
+		require.NoError(t, err)
+	}
+
+	var names []string
+	for i := 0; i < 5; i++ {
+		var bOpts BuilderOptions
+		require.NoError(t, b.Queue.Dequeue(&bOpts))
+		names = append(names, bOpts.Image.Name)
+	}
+	assert.Equal(t, []string{"a1", "b1", "team1", "a2", "a3"}, names, "The builds of the owners should run in turn")
+
+	assert.Equal(t, "team", queueOwner(BuilderOptions{Git: GitOptions{URL: "github.com/org/repo", Credentials: "team"}}), "Builds with credentials should be owned by them")
+	assert.Equal(t, "github.com/org/repo", defaultOwner(BuilderOptions{Git: GitOptions{URL: "user:token@github.com/org/repo.git"}}), "Default owners should not contain credentials")
+	assert.Empty(t, defaultOwner(BuilderOptions{Context: ContextOptions{Uploaded: true}}))
+}
@@ -0,0 +1,80 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/platforms"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// resolvePlatforms returns the normalized list of platforms to build for.
+// `Platforms` takes precedence over `CustomPlatform`, and if none is given
+// the platform of the running instance is used.
+func resolvePlatforms(customPlatform string, requested []string) ([]string, error) {
+	if len(requested) == 0 && customPlatform != "" {
+		requested = []string{customPlatform}
+	}
+	if len(requested) == 0 {
+		return []string{platforms.Format(platforms.Normalize(platforms.DefaultSpec()))}, nil
+	}
+
+	seen := make(map[string]bool, len(requested))
+	resolved := make([]string, 0, len(requested))
+	for _, p := range requested {
+		spec, err := platforms.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q: %w", p, err)
+		}
+
+		formatted := platforms.Format(platforms.Normalize(spec))
+		if seen[formatted] {
+			continue
+		}
+		seen[formatted] = true
+		resolved = append(resolved, formatted)
+	}
+	return resolved, nil
+}
+
+// newImageIndex assembles the per-platform images into a manifest list.
+// The index media type follows the media type of the images, so the
+// result is a Docker manifest list for Docker images and an OCI image
+// index for OCI images.
+func newImageIndex(platformNames []string, images []v1.Image) (v1.ImageIndex, error) {
+	if len(platformNames) != len(images) {
+		return nil, fmt.Errorf("expected %d images, got %d", len(platformNames), len(images))
+	}
+
+	indexMediaType := types.DockerManifestList
+	adds := make([]mutate.IndexAddendum, 0, len(images))
+	for i, image := range images {
+		spec, err := platforms.Parse(platformNames[i])
+		if err != nil {
+			return nil, err
+		}
+
+		mediaType, err := image.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mediaType == types.OCIManifestSchema1 {
+			indexMediaType = types.OCIImageIndex
+		}
+
+		adds = append(adds, mutate.IndexAddendum{
+			Add: image,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{
+					OS:           spec.OS,
+					Architecture: spec.Architecture,
+					Variant:      spec.Variant,
+				},
+			},
+		})
+	}
+
+	return mutate.AppendManifests(mutate.IndexMediaType(empty.Index, indexMediaType), adds...), nil
+}
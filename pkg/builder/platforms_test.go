@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePlatforms(t *testing.T) {
+	resolved, err := resolvePlatforms("", []string{"linux/amd64", "linux/arm64", "linux/amd64"})
+	require.NoError(t, err, "Unexpected error")
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, resolved, "Platforms should be normalized and deduplicated")
+
+	resolved, err = resolvePlatforms("linux/arm/v7", nil)
+	require.NoError(t, err, "Unexpected error")
+	assert.Equal(t, []string{"linux/arm/v7"}, resolved, "Custom platform should be used")
+
+	resolved, err = resolvePlatforms("", nil)
+	require.NoError(t, err, "Unexpected error")
+	assert.Len(t, resolved, 1, "Default platform should be used")
+
+	_, err = resolvePlatforms("", []string{"not/a/valid/platform"})
+	assert.Error(t, err, "Expected an error")
+}
+
+func TestBuildMultiPlatform(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/test-repo"},
+		Platforms:      []string{"linux/amd64", "linux/arm64"},
+		Image:          ImageOptions{Name: "multi", Tag: "1h", Destination: "ttl.sh"},
+	}
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+
+	require.NoError(t, b.build(context.Background(), bOpts, newRedactor()), "Error should be nil when building")
+
+	require.Len(t, kaniko.builds, 2, "One build per platform is expected")
+	assert.Equal(t, "linux/amd64", kaniko.builds[0].CustomPlatform)
+	assert.Equal(t, "linux/arm64", kaniko.builds[1].CustomPlatform)
+	assert.Empty(t, kaniko.pushed, "Single images should not be pushed")
+	require.Len(t, kaniko.pushedIdxs, 1, "The image index should be pushed")
+
+	manifest, err := kaniko.pushedIdxs[0].IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, manifest.Manifests, 2, "The index should reference both platforms")
+	assert.Equal(t, "arm64", manifest.Manifests[1].Platform.Architecture)
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	require.Len(t, bd.Platforms, 2, "Per-platform results should be recorded")
+	assert.Equal(t, manifest.Manifests[0].Digest.String(), bd.Platforms[0].Digest)
+	assert.Equal(t, 1, bd.Platforms[0].Layers)
+
+	indexDigest, err := kaniko.pushedIdxs[0].Digest()
+	require.NoError(t, err)
+	require.NotNil(t, bd.Image, "The pushed index should be described")
+	assert.Equal(t, indexDigest.String(), bd.Image.Digest)
+	assert.Equal(t, "ttl.sh/multi@"+indexDigest.String(), bd.Image.Reference)
+	assert.Equal(t, 2, bd.Image.Layers, "Layers should be summed over the platforms")
+	assert.Greater(t, bd.Image.Size, bd.Platforms[0].Size+bd.Platforms[1].Size, "The index size should include its manifest")
+}
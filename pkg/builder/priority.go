@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
+)
+
+const (
+	// PriorityHigh builds are run before the others, e.g. interactive builds
+	PriorityHigh = "high"
+	// PriorityNormal is the priority of the builds which do not set one
+	PriorityNormal = "normal"
+	// PriorityLow builds are run after the others, e.g. nightly rebuilds
+	PriorityLow = "low"
+)
+
+func validatePriority(priority string) error {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return nil
+	}
+	return fmt.Errorf("unknown priority %q, use one of %s, %s and %s", priority, PriorityHigh, PriorityNormal, PriorityLow)
+}
+
+// queuePriority returns the queue lane of a build
+func (b BuilderOptions) queuePriority() redisqueue.Priority {
+	switch b.Priority {
+	case PriorityHigh:
+		return redisqueue.PriorityHigh
+	case PriorityLow:
+		return redisqueue.PriorityLow
+	}
+	return redisqueue.PriorityNormal
+}
@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPriority(t *testing.T) {
+	b, _ := newTestBuilder(t)
+	_, err := b.AddToBuildQueue(BuilderOptions{Git: GitOptions{URL: "github.com/test-username/test-repo"}, Priority: "urgent"})
+	assert.Error(t, err, "Unknown priorities should be rejected")
+
+	for _, build := range []struct{ name, priority string }{
+		{"nightly", PriorityLow},
+		{"default", ""},
+		{"interactive", PriorityHigh},
+	} {
+		_, err := b.AddToBuildQueue(BuilderOptions{
+			Git:      GitOptions{URL: "github.com/test-username/test-repo"},
+			Image:    ImageOptions{Name: build.name},
+			Priority: build.priority,
+		})
+		require.NoError(t, err)
+	}
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		var bOpts BuilderOptions
+		require.NoError(t, b.Queue.Dequeue(&bOpts))
+		names = append(names, bOpts.Image.Name)
+	}
+	assert.Equal(t, []string{"interactive", "default", "nightly"}, names, "Higher priority builds should run first")
+}
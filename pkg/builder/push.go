@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// destinations returns the requested destinations, falling back to the
+// single `Destination` field for requests that do not use the list
+func (o ImageOptions) destinations() []Destination {
+	if len(o.Destinations) > 0 {
+		return o.Destinations
+	}
+	return []Destination{{Registry: o.Destination}}
+}
+
+// reference returns the full image reference for a destination
+func (o ImageOptions) reference(d Destination) string {
+	return fmt.Sprintf("%s/%s:%s", d.Registry, o.Name, o.Tag)
+}
+
+// digestReference returns the image reference pinned by digest for a destination
+func (o ImageOptions) digestReference(d Destination, digest v1.Hash) string {
+	return fmt.Sprintf("%s/%s@%s", d.Registry, o.Name, digest)
+}
+
+func destinationRefs(o ImageOptions) []string {
+	refs := []string{}
+	for _, d := range o.destinations() {
+		refs = append(refs, o.reference(d))
+	}
+	return refs
+}
+
+// pushError reports the destinations the image could not be pushed to. The
+// errors of each push are only in the destination results, but they are
+// kept to tell if the push can be retried.
+type pushError struct {
+	errs         []error
+	failed       []Destination // retried alone, the others have the image
+	destinations int
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("pushing to %d of %d destinations failed", len(e.errs), e.destinations)
+}
+
+func (e *pushError) Unwrap() []error { return e.errs }
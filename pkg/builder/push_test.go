@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMultipleDestinations(t *testing.T) {
+	store := credentials.NewStore(map[string]credentials.Credential{
+		"private": {Username: "bot", Password: "registry-password"},
+	})
+	b, kaniko := newTestBuilder(t, WithCredentials(store))
+	kaniko.pushErrors = map[string]error{
+		"broken.example.com/multi:1h": errors.New("unauthorized: registry-password rejected"),
+	}
+
+	bOpts := BuilderOptions{
+		DockerfilePath: defaultDockerfilePath,
+		Git:            GitOptions{URL: "github.com/test-username/test-repo"},
+		Image: ImageOptions{
+			Name: "multi",
+			Tag:  "1h",
+			Destinations: []Destination{
+				{Registry: "ttl.sh"},
+				{Registry: "private.example.com", Credentials: "private"},
+				{Registry: "broken.example.com"},
+			},
+		},
+	}
+	require.NoError(t, b.SetBuildStatus(bOpts.Image.Name, BuildStatusData{Status: StatusPending}))
+
+	err := b.build(context.Background(), bOpts, b.buildRedactor(bOpts))
+	require.Error(t, err, "A failed destination should fail the build")
+
+	assert.Equal(t, []string{"ttl.sh/multi:1h", "private.example.com/multi:1h"}, kaniko.pushedTo)
+	assert.Nil(t, kaniko.pushAuths[0], "Destinations without credentials use the default keychain")
+	authConfig, err := kaniko.pushAuths[1].Authorization()
+	require.NoError(t, err)
+	assert.Equal(t, "bot", authConfig.Username, "Stored credentials should be used")
+
+	bd, err := b.GetBuildStatus(bOpts.Image.Name)
+	require.NoError(t, err)
+	require.Len(t, bd.Destinations, 3, "Every destination should be reported")
+	assert.True(t, bd.Destinations[0].Pushed)
+	assert.NotEmpty(t, bd.Destinations[0].Digest)
+	assert.True(t, bd.Destinations[1].Pushed)
+	assert.False(t, bd.Destinations[2].Pushed)
+	assert.Equal(t, "unauthorized: [REDACTED] rejected", bd.Destinations[2].Error, "Credentials should be redacted")
+
+	digest, err := kaniko.pushed[0].Digest()
+	require.NoError(t, err)
+	assert.Equal(t, "private.example.com/multi@"+digest.String(), bd.Destinations[1].DigestReference)
+	assert.Empty(t, bd.Destinations[2].DigestReference)
+	require.NotNil(t, bd.Image)
+	assert.Equal(t, "ttl.sh/multi@"+digest.String(), bd.Image.Reference, "The first pushed destination should be referenced")
+}
@@ -0,0 +1,168 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultRetryMaxAttempts does not retry the builds
+	defaultRetryMaxAttempts = 1
+	defaultRetryBackoff     = 30 * time.Second
+
+	// maxRetryAttempts and maxRetryBackoff bound the retry policy of the
+	// requests
+	maxRetryAttempts = 10
+	maxRetryBackoff  = time.Hour
+
+	// retryPromoteInterval is how often the retries which are due are moved
+	// to the queue
+	retryPromoteInterval = time.Second
+)
+
+// RetryOptions is the retry policy of a build. The builds which fail with a
+// transient error, e.g. a registry 5xx error or a network failure, are
+// queued again after a delay.
+type RetryOptions struct {
+	MaxAttempts int    `json:"max_attempts,omitempty"` // including the first one, defaults to the server policy
+	Backoff     string `json:"backoff,omitempty"`      // e.g. 30s, delay before the first retry, doubled after each one
+}
+
+// AttemptResult is a failed attempt of a build
+type AttemptResult struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	EndTime time.Time `json:"end_time"`
+	// RetryAt is when the build is attempted again, zero if it is not
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// retryableError marks an error as transient
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isTransient tells if a build failing with err may succeed if retried
+func isTransient(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.Temporary()
+	}
+
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryPolicy returns the max attempts and the backoff of a build
+func (b *Builder) retryPolicy(opts RetryOptions) (int, time.Duration, error) {
+	maxAttempts := b.retryMaxAttempts
+	if opts.MaxAttempts != 0 {
+		if opts.MaxAttempts < 1 || opts.MaxAttempts > maxRetryAttempts {
+			return 0, 0, fmt.Errorf("retry max attempts must be between 1 and %d", maxRetryAttempts)
+		}
+		maxAttempts = opts.MaxAttempts
+	}
+
+	backoff := b.retryBackoff
+	if opts.Backoff != "" {
+		var err error
+		backoff, err = time.ParseDuration(opts.Backoff)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parsing retry backoff: %w", err)
+		}
+		if backoff <= 0 || backoff > maxRetryBackoff {
+			return 0, 0, fmt.Errorf("retry backoff must be positive and at most %s", maxRetryBackoff)
+		}
+	}
+	return maxAttempts, backoff, nil
+}
+
+// retryDelay returns the delay before the retry following an attempt
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+// retryOf returns the request of the retry of a build which failed with err.
+// After a partial push, only the destinations which failed are retried.
+func retryOf(bOpts BuilderOptions, err error) BuilderOptions {
+	var pushErr *pushError
+	if errors.As(err, &pushErr) && len(pushErr.failed) > 0 {
+		bOpts.Image.Destinations = pushErr.failed
+	}
+	// The secrets were opened for the build, they are queued encrypted only
+	bOpts.Secrets = nil
+	return bOpts
+}
+
+// scheduleRetry queues a failed build again after its backoff delay, if its
+// retry policy allows it, and records the failed attempt
+func (b *Builder) scheduleRetry(bOpts BuilderOptions, errMsg string) (bool, error) {
+	maxAttempts, backoff, err := b.retryPolicy(bOpts.Retry)
+	if err != nil {
+		return false, err
+	}
+	attempt := max(bOpts.Attempt, 1)
+	if attempt >= maxAttempts {
+		return false, nil
+	}
+
+	delay := retryDelay(backoff, attempt)
+	now := time.Now().UTC()
+	retryAt := now.Add(delay)
+
+	bOpts.Attempt = attempt + 1
+	if err := b.Queue.EnqueueAtOwner(bOpts, retryAt, bOpts.queuePriority(), queueOwner(bOpts)); err != nil {
+		return false, err
+	}
+
+	err = b.UpdateBuildStatus(bOpts.Image.Name, BuildStatusData{
+		Status:   StatusPending,
+		ErrorMsg: errMsg,
+		Attempts: []AttemptResult{{Attempt: attempt, Error: errMsg, EndTime: now, RetryAt: retryAt}},
+		Logs:     fmt.Sprintf("Attempt %d of %d failed, retrying in %s\n", attempt, maxAttempts, delay),
+	})
+	return true, err
+}
+
+// promoteRetries moves the retries which are due to the queue until ctx is
+// done
+func (b *Builder) promoteRetries(ctx context.Context) {
+	ticker := time.NewTicker(retryPromoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := b.Queue.PromoteDue(time.Now()); err != nil {
+				b.logger.Error("promoting build retries", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(&transport.Error{StatusCode: http.StatusServiceUnavailable}))
+	assert.False(t, isTransient(&transport.Error{StatusCode: http.StatusUnauthorized}))
+	assert.True(t, isTransient(fmt.Errorf("pushing: %w", &pushError{errs: []error{errors.New("denied"), syscall.ECONNRESET}, destinations: 2})))
+	assert.True(t, isTransient(&retryableError{err: errors.New("unexpected status 502 Bad Gateway")}))
+	assert.False(t, isTransient(errors.New("invalid Dockerfile")))
+	assert.False(t, isTransient(nil))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	b, _ := newTestBuilder(t, WithRetryPolicy(3, time.Minute))
+
+	attempts, backoff, err := b.retryPolicy(RetryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, time.Minute, backoff)
+
+	attempts, backoff, err = b.retryPolicy(RetryOptions{MaxAttempts: 5, Backoff: "10s"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, attempts)
+	assert.Equal(t, 10*time.Second, backoff)
+
+	for _, opts := range []RetryOptions{{MaxAttempts: -1}, {MaxAttempts: maxRetryAttempts + 1}, {Backoff: "soon"}, {Backoff: "-1s"}, {Backoff: "2h"}} {
+		_, _, err := b.retryPolicy(opts)
+		assert.Error(t, err, "%+v should be rejected", opts)
+	}
+
+	assert.Equal(t, 10*time.Second, retryDelay(10*time.Second, 1))
+	assert.Equal(t, 40*time.Second, retryDelay(10*time.Second, 3))
+	assert.Equal(t, maxRetryBackoff, retryDelay(10*time.Minute, 8), "The delay should be capped")
+}
+
+func TestBuildRetry(t *testing.T) {
+	b, kaniko := newTestBuilder(t, WithRetryPolicy(2, time.Second))
+	kaniko.pushErrors = map[string]error{"ttl.sh/retried:1h": &transport.Error{StatusCode: http.StatusBadGateway}}
+
+	res, err := b.AddToBuildQueue(BuilderOptions{
+		Git:     GitOptions{URL: "github.com/test-username/test-repo"},
+		Image:   ImageOptions{Name: "retried"},
+		Attempt: 2, // clients cannot skip the retries
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.Zero(t, bOpts.Attempt)
+	b.runBuild(context.Background(), bOpts)
+
+	bd, err := b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, bd.Status, "A transient failure should be retried")
+	assert.Equal(t, 1, bd.Attempt)
+	require.Len(t, bd.Attempts, 1)
+	assert.Equal(t, 1, bd.Attempts[0].Attempt)
+	assert.NotEmpty(t, bd.Attempts[0].Error)
+	assert.False(t, bd.Attempts[0].RetryAt.IsZero())
+
+	require.NoError(t, b.UpdateBuildStatus(res.ImageName, BuildStatusData{Status: StatusBuilding, Logs: "retrying\n"}))
+	bd, err = b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, bd.Attempts[0].Error, bd.ErrorMsg, "The retry reason should survive the updates without an error")
+
+	assert.ErrorIs(t, b.Queue.Dequeue(&bOpts), redisqueue.ErrQueueEmpty, "The retry should be delayed")
+	n, err := b.Queue.PromoteDue(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.Equal(t, 2, bOpts.Attempt)
+
+	// The last attempt fails for good
+	b.runBuild(context.Background(), bOpts)
+	bd, err = b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, bd.Status)
+	assert.Equal(t, 2, bd.Attempt)
+	require.Len(t, bd.Attempts, 2)
+	assert.True(t, bd.Attempts[1].RetryAt.IsZero())
+
+	// Permanent errors are not retried
+	kaniko.pushErrors["ttl.sh/denied:1h"] = &transport.Error{StatusCode: http.StatusUnauthorized}
+	res, err = b.AddToBuildQueue(BuilderOptions{
+		Git:   GitOptions{URL: "github.com/test-username/test-repo"},
+		Image: ImageOptions{Name: "denied"},
+		Retry: RetryOptions{MaxAttempts: 5},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	b.runBuild(context.Background(), bOpts)
+	bd, err = b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, bd.Status)
+	assert.Len(t, bd.Attempts, 1)
+}
+
+func TestBuildRetryFailedDestinations(t *testing.T) {
+	b, kaniko := newTestBuilder(t, WithRetryPolicy(2, time.Second))
+	kaniko.pushErrors = map[string]error{"registry.example.com/partial:1h": &transport.Error{StatusCode: http.StatusBadGateway}}
+
+	res, err := b.AddToBuildQueue(BuilderOptions{
+		Git: GitOptions{URL: "github.com/test-username/test-repo"},
+		Image: ImageOptions{Name: "partial", Destinations: []Destination{
+			{Registry: "ttl.sh"},
+			{Registry: "registry.example.com"},
+		}},
+		Secrets: map[string]string{"TOKEN": "s3cr3t"},
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	b.runBuild(context.Background(), bOpts)
+	assert.Equal(t, []string{"ttl.sh/partial:1h"}, kaniko.pushedTo)
+
+	_, err = b.Queue.PromoteDue(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	var retry BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&retry))
+	assert.Equal(t, []Destination{{Registry: "registry.example.com"}}, retry.Image.Destinations, "Only the failed destinations should be retried")
+	assert.Empty(t, retry.Secrets, "Secrets should not be queued in plaintext")
+	assert.NotEmpty(t, retry.EncryptedSecrets)
+
+	kaniko.pushErrors = nil
+	b.runBuild(context.Background(), retry)
+	assert.Equal(t, []string{"ttl.sh/partial:1h", "registry.example.com/partial:1h"}, kaniko.pushedTo)
+
+	bd, err := b.GetBuildStatus(res.ImageName)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, bd.Status)
+	assert.Empty(t, bd.ErrorMsg, "A succeeded retry should clear the error of the failed attempt")
+	require.Len(t, bd.Destinations, 2, "The results of the first attempt should be kept")
+	for _, d := range bd.Destinations {
+		assert.True(t, d.Pushed, d.Reference)
+	}
+}
@@ -0,0 +1,422 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/cron"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	schedulesKey      = "schedules"        // hash of the schedules by id
+	schedulesDueKey   = "schedules:due"    // sorted set of the active schedules by their next run, in unix milliseconds
+	scheduleLeaderKey = "schedules:leader" // id of the instance queuing the scheduled builds
+
+	// scheduleInterval is how often the leader queues the builds which are
+	// due, and renews its leadership
+	scheduleInterval = 10 * time.Second
+	// scheduleLeaderTTL is how long the leadership of an instance which
+	// stopped renewing it lasts
+	scheduleLeaderTTL = 3 * scheduleInterval
+
+	// scheduleBatchSize bounds the schedules run by a single tick
+	scheduleBatchSize = 100
+)
+
+// Schedule queues a build from a template at the times of a cron
+// expression, e.g. to rebuild an image nightly with the security fixes of
+// its base image.
+type Schedule struct {
+	ID       string         `json:"id"`
+	Cron     string         `json:"cron"`               // e.g. "0 2 * * *" or "@daily", see cron.Parse
+	Timezone string         `json:"timezone,omitempty"` // IANA name of the timezone of the cron expression, UTC by default
+	Build    BuilderOptions `json:"build"`
+	Paused   bool           `json:"paused"`
+
+	// SecretNames are the names of the secrets of the build, whose values
+	// are only stored encrypted and never returned
+	SecretNames []string `json:"secret_names,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	NextRun   time.Time `json:"next_run"` // zero while paused
+	LastRun   time.Time `json:"last_run"`
+
+	// LastImage is the image name of the last build, and LastError why the
+	// last build was not queued
+	LastImage string `json:"last_image,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (s Schedule) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *Schedule) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, s)
+}
+
+// next returns the first time the schedule is due after now
+func (s Schedule) next(now time.Time) (time.Time, error) {
+	expr, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+
+	next := expr.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	return next.UTC(), nil
+}
+
+// CreateSchedule validates and stores a new schedule, and returns it. The
+// secrets of its build are encrypted like the ones of queued builds. The
+// validation errors wrap ErrInvalidSchedule.
+func (b *Builder) CreateSchedule(s Schedule) (Schedule, error) {
+	now := time.Now().UTC()
+	next, err := s.next(now)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	if s.Build.Context.Uploaded || len(s.Build.ContextArchive) > 0 {
+		return Schedule{}, fmt.Errorf("%w: builds with an uploaded context cannot be scheduled", ErrInvalidSchedule)
+	}
+	// Each build fills in its own defaults, e.g. a new image name
+	build := s.Build
+	if err := b.prepareBuild(&build); err != nil {
+		return Schedule{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	s.Build.Attempt = 0
+	s.Build.DedupKey = ""
+	s.SecretNames = nil
+	for name := range s.Build.Secrets {
+		s.SecretNames = append(s.SecretNames, name)
+	}
+	sort.Strings(s.SecretNames)
+	s.Build.EncryptedSecrets, err = sealSecrets(b.secretsKey, s.Build.Secrets)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("encrypting secrets: %w", err)
+	}
+	s.Build.Secrets = nil
+
+	s.ID = uuid.NewString()
+	s.CreatedAt = now
+	s.NextRun = time.Time{}
+	if !s.Paused {
+		s.NextRun = next
+	}
+	s.LastRun = time.Time{}
+	s.LastImage = ""
+	s.LastError = ""
+
+	_, err = b.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		return storeSchedule(pipe, s)
+	})
+	if err != nil {
+		return Schedule{}, fmt.Errorf("storing schedule: %w", err)
+	}
+	return s, nil
+}
+
+// storeSchedule stores a schedule and its next run, unless it is paused
+func storeSchedule(pipe redis.Pipeliner, s Schedule) error {
+	if err := pipe.HSet(schedulesKey, s.ID, s).Err(); err != nil {
+		return err
+	}
+	if s.Paused || s.NextRun.IsZero() {
+		return pipe.ZRem(schedulesDueKey, s.ID).Err()
+	}
+	return pipe.ZAdd(schedulesDueKey, redis.Z{Score: float64(s.NextRun.UnixMilli()), Member: s.ID}).Err()
+}
+
+func (b *Builder) GetSchedule(id string) (Schedule, error) {
+	var s Schedule
+	if err := b.redisClient.HGet(schedulesKey, id).Scan(&s); err != nil {
+		if err == redis.Nil {
+			return Schedule{}, ErrScheduleNotFound
+		}
+		return Schedule{}, err
+	}
+	return s, nil
+}
+
+// ListSchedules returns the schedules, the oldest first
+func (b *Builder) ListSchedules() ([]Schedule, error) {
+	values, err := b.redisClient.HGetAll(schedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]Schedule, 0, len(values))
+	for id, value := range values {
+		var s Schedule
+		if err := s.UnmarshalBinary([]byte(value)); err != nil {
+			b.logger.Error("decoding schedule", zap.String("schedule_id", id), zap.Error(err))
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	return schedules, nil
+}
+
+// PauseSchedule stops queuing the builds of a schedule until it is resumed
+func (b *Builder) PauseSchedule(id string) (Schedule, error) {
+	return b.modifySchedule(id, func(s *Schedule) error {
+		s.Paused = true
+		s.NextRun = time.Time{}
+		return nil
+	})
+}
+
+// ResumeSchedule queues the builds of a paused schedule again, from its next
+// time on: the runs missed while paused are skipped
+func (b *Builder) ResumeSchedule(id string) (Schedule, error) {
+	return b.modifySchedule(id, func(s *Schedule) error {
+		if !s.Paused {
+			return nil
+		}
+		next, err := s.next(time.Now())
+		if err != nil {
+			return err
+		}
+		s.Paused = false
+		s.NextRun = next
+		return nil
+	})
+}
+
+func (b *Builder) DeleteSchedule(id string) error {
+	n, err := b.redisClient.HDel(schedulesKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if err := b.redisClient.ZRem(schedulesDueKey, id).Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// modifySchedule applies modify to a stored schedule atomically, and returns
+// the modified schedule
+func (b *Builder) modifySchedule(id string, modify func(s *Schedule) error) (Schedule, error) {
+	var s Schedule
+	txf := func(tx *redis.Tx) error {
+		s = Schedule{}
+		if err := tx.HGet(schedulesKey, id).Scan(&s); err != nil {
+			if err == redis.Nil {
+				return ErrScheduleNotFound
+			}
+			return err
+		}
+
+		if err := modify(&s); err != nil {
+			return err
+		}
+
+		_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+			return storeSchedule(pipe, s)
+		})
+		return err
+	}
+
+	for i := 0; i < maxStatusUpdateRetries; i++ {
+		err := b.redisClient.Watch(txf, schedulesKey)
+		if err == nil {
+			return s, nil
+		}
+		if err != redis.TxFailedErr {
+			return Schedule{}, err
+		}
+	}
+	return Schedule{}, fmt.Errorf("updating schedule %s: too many concurrent updates", id)
+}
+
+// renewLeaderScript extends the leadership KEYS[1] of instance ARGV[1] by
+// ARGV[2] milliseconds, if it still holds it
+var renewLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaderScript gives up the leadership KEYS[1] of instance ARGV[1],
+// if it still holds it
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// leadSchedules tells whether this instance is the one queuing the scheduled
+// builds, electing it if there is none, and renews its leadership
+func (b *Builder) leadSchedules() (bool, error) {
+	elected, err := b.redisClient.SetNX(scheduleLeaderKey, b.instanceID, scheduleLeaderTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("electing schedules leader: %w", err)
+	}
+	if elected {
+		return true, nil
+	}
+
+	renewed, err := renewLeaderScript.Run(b.redisClient, []string{scheduleLeaderKey}, b.instanceID, scheduleLeaderTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("renewing schedules leadership: %w", err)
+	}
+	return renewed == 1, nil
+}
+
+// runSchedules queues the scheduled builds which are due until ctx is done,
+// while this instance is the leader
+func (b *Builder) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	leader := false
+	for {
+		select {
+		case <-ctx.Done():
+			if leader {
+				// Another instance takes over without waiting for the leadership to expire
+				if err := releaseLeaderScript.Run(b.redisClient, []string{scheduleLeaderKey}, b.instanceID).Err(); err != nil {
+					b.logger.Error("releasing schedules leadership", zap.Error(err))
+				}
+			}
+			return
+		case <-ticker.C:
+			ok, err := b.leadSchedules()
+			if err != nil {
+				b.logger.Error("electing schedules leader", zap.Error(err))
+				continue
+			}
+			if ok != leader {
+				b.logger.Info("schedules leadership changed", zap.Bool("leader", ok))
+				leader = ok
+			}
+			if !leader {
+				continue
+			}
+
+			if err := b.runDueSchedules(time.Now()); err != nil {
+				b.logger.Error("running schedules", zap.Error(err))
+			}
+		}
+	}
+}
+
+// runDueSchedules queues the builds of the schedules which are due at now
+func (b *Builder) runDueSchedules(now time.Time) error {
+	ids, err := b.redisClient.ZRangeByScore(schedulesDueKey, redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprint(now.UnixMilli()),
+		Count: scheduleBatchSize,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := b.runSchedule(id, now); err != nil {
+			b.logger.Error("running schedule", zap.String("schedule_id", id), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// runSchedule queues the build of a schedule which is due at now, and sets
+// its next run. The runs missed, e.g. while no instance was up, are skipped.
+func (b *Builder) runSchedule(id string, now time.Time) error {
+	var due *Schedule
+	_, err := b.modifySchedule(id, func(s *Schedule) error {
+		due = nil
+		if s.Paused || s.NextRun.After(now) {
+			return nil
+		}
+
+		next, err := s.next(now)
+		if err != nil {
+			// The timezone database changed, the schedule cannot run anymore
+			s.Paused = true
+			s.NextRun = time.Time{}
+			s.LastError = err.Error()
+			return nil
+		}
+		s.NextRun = next
+		s.LastRun = now.UTC()
+		due = s
+		return nil
+	})
+	if errors.Is(err, ErrScheduleNotFound) {
+		return b.redisClient.ZRem(schedulesDueKey, id).Err()
+	}
+	if err != nil || due == nil {
+		return err
+	}
+
+	image, err := b.queueScheduledBuild(*due)
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		b.logger.Warn("scheduled build not queued", zap.String("schedule_id", id), zap.Error(err))
+	}
+
+	_, err = b.modifySchedule(id, func(s *Schedule) error {
+		if image != "" {
+			s.LastImage = image
+		}
+		s.LastError = lastError
+		return nil
+	})
+	if errors.Is(err, ErrScheduleNotFound) {
+		return nil
+	}
+	return err
+}
+
+// queueScheduledBuild queues the build of a schedule, unless its previous
+// build is still pending or running, and returns its image name
+func (b *Builder) queueScheduledBuild(s Schedule) (string, error) {
+	if s.LastImage != "" {
+		status, err := b.GetBuildStatus(s.LastImage)
+		if err == nil && (status.Status == StatusPending || status.Status == StatusBuilding) {
+			return "", fmt.Errorf("skipped, build %s is still %s", s.LastImage, status.Status)
+		}
+	}
+
+	opts := s.Build
+	secrets, err := openSecrets(b.secretsKey, opts.EncryptedSecrets)
+	if err != nil {
+		return "", fmt.Errorf("decrypting secrets: %w", err)
+	}
+	opts.Secrets = secrets
+	opts.EncryptedSecrets = nil
+	// The point of a scheduled build is to pick up what changed since the
+	// previous one, e.g. its base image
+	opts.NoDedup = true
+
+	res, err := b.AddToBuildQueue(opts)
+	if err != nil {
+		return "", err
+	}
+	return res.ImageName, nil
+}
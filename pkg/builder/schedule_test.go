@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedules(t *testing.T) {
+	b, _ := newTestBuilder(t)
+
+	_, err := b.CreateSchedule(Schedule{Cron: "0 25 * * *", Build: BuilderOptions{Git: GitOptions{URL: "github.com/test-username/test-repo"}}})
+	assert.ErrorIs(t, err, ErrInvalidSchedule, "Invalid cron expressions should be rejected")
+	_, err = b.CreateSchedule(Schedule{Cron: "@daily", Timezone: "Mars/Olympus", Build: BuilderOptions{Git: GitOptions{URL: "github.com/test-username/test-repo"}}})
+	assert.ErrorIs(t, err, ErrInvalidSchedule, "Unknown timezones should be rejected")
+	_, err = b.CreateSchedule(Schedule{Cron: "@daily", Build: BuilderOptions{Context: ContextOptions{Uploaded: true}, ContextArchive: []byte{0x1f}}})
+	assert.ErrorIs(t, err, ErrInvalidSchedule, "Uploaded contexts should be rejected")
+
+	s, err := b.CreateSchedule(Schedule{
+		Cron: "0 2 * * *",
+		Build: BuilderOptions{
+			Git:     GitOptions{URL: "github.com/test-username/test-repo"},
+			Image:   ImageOptions{Name: "nightly"},
+			Secrets: map[string]string{"TOKEN": "s3cr3t"},
+		},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, s.ID)
+	assert.Equal(t, 2, s.NextRun.Hour())
+	assert.Nil(t, s.Build.Secrets, "Secrets should not be stored in plaintext")
+	assert.NotEmpty(t, s.Build.EncryptedSecrets)
+	assert.Equal(t, []string{"TOKEN"}, s.SecretNames)
+
+	schedules, err := b.ListSchedules()
+	require.NoError(t, err)
+	require.Len(t, schedules, 1)
+	assert.Equal(t, s.ID, schedules[0].ID)
+
+	// Nothing is due before the next run
+	require.NoError(t, b.runDueSchedules(s.NextRun.Add(-time.Minute)))
+	var bOpts BuilderOptions
+	assert.ErrorIs(t, b.Queue.Dequeue(&bOpts), redisqueue.ErrQueueEmpty)
+
+	require.NoError(t, b.runDueSchedules(s.NextRun))
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	assert.Equal(t, "nightly", bOpts.Image.Name)
+	assert.True(t, bOpts.NoDedup, "Scheduled builds should not be deduplicated")
+	secrets, err := openSecrets(b.secretsKey, bOpts.EncryptedSecrets)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"TOKEN": "s3cr3t"}, secrets, "Secrets should reach the build")
+
+	ran, err := b.GetSchedule(s.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "nightly", ran.LastImage)
+	assert.Equal(t, s.NextRun.Add(24*time.Hour), ran.NextRun, "The next run should be set")
+
+	// A run is skipped while the previous build is pending
+	require.NoError(t, b.runDueSchedules(ran.NextRun))
+	assert.ErrorIs(t, b.Queue.Dequeue(&bOpts), redisqueue.ErrQueueEmpty)
+	skipped, err := b.GetSchedule(s.ID)
+	require.NoError(t, err)
+	assert.Contains(t, skipped.LastError, "still pending")
+
+	paused, err := b.PauseSchedule(s.ID)
+	require.NoError(t, err)
+	assert.True(t, paused.Paused)
+	assert.True(t, paused.NextRun.IsZero())
+	require.NoError(t, b.runDueSchedules(time.Now().Add(48*time.Hour)))
+	_, err = b.GetSchedule(s.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, b.Queue.Dequeue(&bOpts), redisqueue.ErrQueueEmpty, "Paused schedules should not run")
+
+	resumed, err := b.ResumeSchedule(s.ID)
+	require.NoError(t, err)
+	assert.False(t, resumed.Paused)
+	assert.True(t, resumed.NextRun.After(time.Now()))
+
+	require.NoError(t, b.DeleteSchedule(s.ID))
+	assert.ErrorIs(t, b.DeleteSchedule(s.ID), ErrScheduleNotFound)
+	_, err = b.GetSchedule(s.ID)
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
+
+func TestScheduleLeader(t *testing.T) {
+	b, _ := newTestBuilder(t)
+	other := NewBuilder(b.redisClient, b.logger)
+
+	leader, err := b.leadSchedules()
+	require.NoError(t, err)
+	assert.True(t, leader, "The first instance should be elected")
+	leader, err = other.leadSchedules()
+	require.NoError(t, err)
+	assert.False(t, leader, "A single instance should be elected")
+	leader, err = b.leadSchedules()
+	require.NoError(t, err)
+	assert.True(t, leader, "The leader should renew its leadership")
+
+	require.NoError(t, releaseLeaderScript.Run(b.redisClient, []string{scheduleLeaderKey}, b.instanceID).Err())
+	leader, err = other.leadSchedules()
+	require.NoError(t, err)
+	assert.True(t, leader, "Another instance should take over a released leadership")
+}
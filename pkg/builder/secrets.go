@@ -0,0 +1,194 @@
+package builder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	secretsKeySize  = 32 // AES-256
+	redactedMessage = "[REDACTED]"
+
+	// secretsDir is where the secrets of a build are written, one file per
+	// secret, for the RUN commands to read. It is kept out of the snapshots,
+	// so the secrets never end up in the image.
+	secretsDir = "/run/secrets"
+)
+
+var (
+	ErrInvalidSecretsKey = fmt.Errorf("secrets key must be %d bytes encoded in base64", secretsKeySize)
+	ErrNoSecretsKey      = errors.New("build secrets require a secrets key to be configured")
+)
+
+// ParseSecretsKey decodes a base64 encoded secrets key and validates its size
+func ParseSecretsKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecretsKey, err)
+	}
+	if len(key) != secretsKeySize {
+		return nil, ErrInvalidSecretsKey
+	}
+	return key, nil
+}
+
+// newSecretsKey generates a random secrets key
+func newSecretsKey() ([]byte, error) {
+	key := make([]byte, secretsKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sealSecrets encrypts the secrets with AES-GCM, so they are never stored in
+// the queue in plaintext. The nonce is prepended to the ciphertext.
+func sealSecrets(key []byte, secrets map[string]string) ([]byte, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openSecrets decrypts the secrets sealed by sealSecrets
+func openSecrets(key []byte, sealed []byte) (map[string]string, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed secrets are too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secrets: %w", err)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func secretValues(secrets map[string]string) []string {
+	values := make([]string, 0, len(secrets))
+	for _, v := range secrets {
+		values = append(values, v)
+	}
+	return values
+}
+
+// buildRedactor returns a redactor hiding the build secrets and the secrets
+// of the stored credentials the build uses
+func (b *Builder) buildRedactor(bOpts BuilderOptions) *redactor {
+	values := secretValues(bOpts.Secrets)
+
+	names := []string{bOpts.Git.Credentials}
+	for _, d := range bOpts.Image.destinations() {
+		names = append(names, d.Credentials)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if cred, err := b.credentials.Get(name); err == nil {
+			values = append(values, cred.Secrets()...)
+		}
+	}
+	return newRedactor(values...)
+}
+
+// redactor replaces known secret values in a text
+type redactor struct {
+	values []string
+}
+
+func newRedactor(values ...string) *redactor {
+	r := &redactor{}
+	for _, v := range values {
+		if v != "" {
+			r.values = append(r.values, v)
+		}
+	}
+	// Replace the longest values first, so a secret containing another one
+	// does not get partially revealed
+	sort.Slice(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+	return r
+}
+
+func (r *redactor) Redact(s string) string {
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, redactedMessage)
+	}
+	return s
+}
+
+// writeSecretFiles writes each secret to a file of dir named after it, and
+// returns a function removing them
+func writeSecretFiles(dir string, secrets map[string]string) (func(), error) {
+	if len(secrets) == 0 {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating secrets directory: %w", err)
+	}
+
+	var paths []string
+	remove := func() {
+		for _, p := range paths {
+			os.Remove(p)
+		}
+	}
+	for name, value := range secrets {
+		p := filepath.Join(dir, name)
+		paths = append(paths, p)
+		if err := os.WriteFile(p, []byte(value), 0400); err != nil {
+			remove()
+			return nil, fmt.Errorf("writing secret %s: %w", name, err)
+		}
+	}
+	return remove, nil
+}
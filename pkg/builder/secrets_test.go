@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealAndOpenSecrets(t *testing.T) {
+	key, err := newSecretsKey()
+	require.NoError(t, err, "Error should be nil when generating a key")
+
+	secrets := map[string]string{"TOKEN": "s3cr3t"}
+	sealed, err := sealSecrets(key, secrets)
+	require.NoError(t, err, "Error should be nil when sealing secrets")
+	assert.NotContains(t, string(sealed), "s3cr3t", "Sealed secrets should not contain the plaintext")
+
+	opened, err := openSecrets(key, sealed)
+	require.NoError(t, err, "Error should be nil when opening secrets")
+	assert.Equal(t, secrets, opened, "Opened secrets should match")
+
+	otherKey, err := newSecretsKey()
+	require.NoError(t, err, "Error should be nil when generating a key")
+	_, err = openSecrets(otherKey, sealed)
+	assert.Error(t, err, "Opening with another key should fail")
+}
+
+func TestSecretFiles(t *testing.T) {
+	b, kaniko := newTestBuilder(t)
+	secretsDir := b.backend.(*KanikoBackend).secretsDir
+
+	var secret []byte
+	kaniko.onBuild = func() {
+		secret, _ = os.ReadFile(filepath.Join(secretsDir, "TOKEN"))
+	}
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git:       GitOptions{URL: "github.com/test-username/test-repo"},
+		Image:     ImageOptions{Name: "secrets"},
+		BuildArgs: []string{"VERSION=1.0"},
+		Secrets:   map[string]string{"TOKEN": "s3cr3t"},
+	})
+	require.NoError(t, err)
+
+	var bOpts BuilderOptions
+	require.NoError(t, b.Queue.Dequeue(&bOpts))
+	b.runBuild(context.Background(), bOpts)
+
+	require.Len(t, kaniko.builds, 1)
+	assert.Equal(t, "s3cr3t", string(secret), "Secrets should be readable from their files during the build")
+	assert.Equal(t, []string{"VERSION=1.0"}, []string(kaniko.builds[0].BuildArgs), "Secrets should not be build args")
+	assert.NoFileExists(t, filepath.Join(secretsDir, "TOKEN"), "Secret files should be removed after the build")
+	assert.Contains(t, b.ignorePaths(), "/run/secrets", "Secret files should be kept out of the snapshots")
+}
+
+func TestSecretsWithoutKey(t *testing.T) {
+	b, _ := newTestBuilder(t)
+	b.secretsKey = nil
+
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git:     GitOptions{URL: "github.com/test-username/test-repo"},
+		Secrets: map[string]string{"TOKEN": "s3cr3t"},
+	})
+	assert.ErrorIs(t, err, ErrNoSecretsKey)
+}
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor("s3cr3t", "s3cr3t-long", "")
+	assert.Equal(t, "token=[REDACTED] other=[REDACTED]", r.Redact("token=s3cr3t other=s3cr3t-long"))
+	assert.Equal(t, "nothing to hide", r.Redact("nothing to hide"))
+}
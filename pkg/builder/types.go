@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/celestiaorg/dockwiz/pkg/lint"
 	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
 	"github.com/go-redis/redis"
 	"go.uber.org/zap"
@@ -18,23 +22,68 @@ const (
 	defaultImageTag         = "1h"
 	defaultRedisMsgTTL      = 24 * time.Hour
 	defaultImageDestination = "ttl.sh"
+	defaultMaxBuildTimeout  = time.Hour
+	defaultArtifactsDir     = "/var/lib/dockwiz/artifacts"
+	dequeueErrorDelay       = time.Second
 )
 
-var ErrBuildNotFound = errors.New("build not found")
+var (
+	ErrBuildNotFound       = errors.New("build not found")
+	ErrBuildNotCancellable = errors.New("build is already finished")
+	ErrBuildCancelled      = errors.New("build cancelled")
+	ErrBuildTimedOut       = errors.New("build timed out")
+	ErrArtifactNotFound    = errors.New("build artifact not found")
+	ErrScheduleNotFound    = errors.New("schedule not found")
+	ErrInvalidSchedule     = errors.New("invalid schedule")
+)
 
 type Builder struct {
 	redisClient     *redis.Client
 	logger          *zap.Logger
 	Queue           *redisqueue.Queue
 	startCancelFunc context.CancelFunc
-	kaniko          KanikoInterface
+	workers         sync.WaitGroup
+	backend         BuildBackend
+	secretsKey      []byte
+	credentials     *credentials.Store
+
+	kanikoExecutable       string // when set, builds run in child processes of this executable
+	workspaceRoot          string // every build gets its own directory in here
+	maxBuildTimeout        time.Duration
+	localContextRoot       string // builds can use the directories in here as their context
+	contextClient          *http.Client
+	contextURLAllowedHosts []string // hosts context archives can be downloaded from, any if empty
+	defaultCacheRepo       string
+	cacheTTL               time.Duration
+	artifactsDir           string // tarballs of the builds which are not pushed
+	linter                 *lint.Linter
+	dedupWindow            time.Duration // identical builds are deduplicated within it, disabled if 0
+	retryMaxAttempts       int
+	retryBackoff           time.Duration
+	maxDiskUsage           float64        // percent of the workspace filesystem above which no build starts, disabled if 0
+	instanceID             string         // identifies the workers of this instance to the reliable queue
+	visibilityTimeout      time.Duration  // builds are reserved and requeued if their worker stops responding, disabled if 0
+	starvationLimit        int            // builds of higher priorities run in a row before a waiting lower priority build
+	ownerLimit             int            // running builds of an owner, unlimited if 0
+	ownerLimits            map[string]int // running builds of specific owners, overriding ownerLimit
+
+	activeMu     sync.Mutex
+	activeBuilds map[string]struct{} // builds with a workspace on this instance
 }
 
 type GitOptions struct {
-	URL               string `json:"url"`
-	Branch            string `json:"branch"`
-	SingleBranch      bool   `json:"single_branch"`
-	RecurseSubmodules bool   `json:"recurse_submodules"`
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	Tag    string `json:"tag"`
+	Ref    string `json:"ref"`    // e.g. refs/pull/123/head
+	Commit string `json:"commit"` // full SHA, checked out after cloning the branch, tag or ref if given
+
+	// Credentials is the name of a stored credential used to clone a private
+	// repository, over SSH if it has a private key and HTTPS otherwise
+	Credentials string `json:"credentials,omitempty"`
+
+	SingleBranch      bool `json:"single_branch"`
+	RecurseSubmodules bool `json:"recurse_submodules"`
 }
 
 type ImageOptions struct {
@@ -42,16 +91,72 @@ type ImageOptions struct {
 	Name   string `json:"name"`
 	Tag    string `json:"tag"`
 
-	// TODO: add support for multiple destinations with credentials for future
-	Destination string `json:"destination"` // Where to push the image
+	Destination  string        `json:"destination"`  // Where to push the image
+	Destinations []Destination `json:"destinations"` // Takes precedence over Destination
+}
+
+// Destination is a registry to push the image to
+type Destination struct {
+	Registry    string `json:"registry"`
+	Credentials string `json:"credentials"` // Name of the stored credentials, empty to use the default keychain
 }
 
 type BuilderOptions struct {
-	DockerfilePath string       `json:"dockerfile_path"`
-	Git            GitOptions   `json:"git_options"`
-	CustomPlatform string       `json:"custom_platform"`
-	Image          ImageOptions `json:"image"`
-	BuildArgs      []string     `json:"build_args"`
+	DockerfilePath string         `json:"dockerfile_path"`
+	Git            GitOptions     `json:"git_options"`
+	Context        ContextOptions `json:"context"` // build context when there is no git url
+	CustomPlatform string         `json:"custom_platform"`
+	Platforms      []string       `json:"platforms"` // e.g. linux/amd64, linux/arm64
+	Image          ImageOptions   `json:"image"`
+	BuildArgs      []string       `json:"build_args"` // KEY=VALUE pairs
+	Timeout        string         `json:"timeout"`    // e.g. 20m, defaults to the server max build timeout
+	Target         string         `json:"target"`     // Dockerfile stage to build, the last one by default
+
+	Cache CacheOptions `json:"cache"`
+
+	// Priority is the queue lane of the build: PriorityHigh, PriorityNormal
+	// (default) or PriorityLow
+	Priority string `json:"priority,omitempty"`
+
+	// Output is where the image goes: OutputRegistry (default) pushes it,
+	// OutputTar and OutputOCI save it as an artifact to download instead
+	Output string `json:"output,omitempty"`
+
+	// Labels are added to the image, along the labels dockwiz sets to link
+	// it to its source and build (see sourceLabels)
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// ContextDir is the directory of the build context relative to the root
+	// of the git repository or archive. DockerfilePath is relative to it.
+	ContextDir string `json:"context_dir,omitempty"`
+
+	// DockerfileContent is an inline Dockerfile written into the build
+	// context, used instead of DockerfilePath
+	DockerfileContent string `json:"dockerfile_content,omitempty"`
+
+	// Lint rejects the build before it is queued if its Dockerfile has lint
+	// errors (see Builder.Lint)
+	Lint bool `json:"lint,omitempty"`
+
+	// Retry is the retry policy of the build. Attempt is the number of the
+	// queued attempt, set by dockwiz.
+	Retry   RetryOptions `json:"retry"`
+	Attempt int          `json:"attempt,omitempty"`
+
+	// NoDedup builds the image even if an identical build was requested
+	// recently. DedupKey is set by dockwiz when the build is deduplicated.
+	NoDedup  bool   `json:"no_dedup,omitempty"`
+	DedupKey string `json:"dedup_key,omitempty"`
+
+	// Secrets are exposed to the RUN commands of the build as files of
+	// /run/secrets, kept out of the image. They are encrypted before being
+	// queued and their values are redacted from the build logs.
+	Secrets          map[string]string `json:"secrets,omitempty"`
+	EncryptedSecrets []byte            `json:"encrypted_secrets,omitempty"`
+
+	// ContextArchive is an uploaded tar.gz build context. It is stored
+	// apart from the queue, so it is never part of the queued request.
+	ContextArchive []byte `json:"-"`
 }
 
 func (b BuilderOptions) MarshalBinary() ([]byte, error) {
@@ -65,6 +170,10 @@ func (b *BuilderOptions) UnmarshalBinary(data []byte) error {
 type BuildResult struct {
 	ImageName string `json:"image_name"`
 	ImageTag  string `json:"image_tag"`
+
+	// Deduplicated is set when the result is the one of an identical build
+	// requested before, see WithDedupWindow
+	Deduplicated bool `json:"deduplicated,omitempty"`
 }
 
 /*------*/
@@ -76,6 +185,42 @@ type BuildStatusData struct {
 	StartTime    time.Time   `json:"start_time"`
 	EndTime      time.Time   `json:"end_time"`
 	Logs         string      `json:"logs"`
+	Commit       string      `json:"commit,omitempty"` // resolved commit SHA of the build context
+
+	Image        *ImageResult        `json:"image,omitempty"` // set once the image is pushed
+	CacheSteps   []CacheStep         `json:"cache_steps,omitempty"`
+	Artifact     *ArtifactResult     `json:"artifact,omitempty"` // set once the image is saved, when not pushed
+	Platforms    []PlatformResult    `json:"platforms,omitempty"`
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+
+	Attempt  int             `json:"attempt,omitempty"`  // current attempt, starting at 1
+	Attempts []AttemptResult `json:"attempts,omitempty"` // failed attempts, see RetryOptions
+	Requeues int             `json:"requeues,omitempty"` // times the build was requeued after its worker stopped responding
+}
+
+// ImageResult describes the pushed image, so it can be pulled by digest
+type ImageResult struct {
+	Digest    string `json:"digest"`
+	Reference string `json:"reference,omitempty"` // registry/name@sha256:..., empty if not pushed
+	Size      int64  `json:"size"`                // bytes of the manifests, configs and compressed layers
+	Layers    int    `json:"layers"`
+}
+
+// DestinationResult is the outcome of pushing the image to a single destination
+type DestinationResult struct {
+	Reference       string `json:"reference"`
+	Pushed          bool   `json:"pushed"`
+	Digest          string `json:"digest,omitempty"`
+	DigestReference string `json:"digest_reference,omitempty"` // registry/name@sha256:...
+	Error           string `json:"error,omitempty"`
+}
+
+// PlatformResult describes the image built for a single platform
+type PlatformResult struct {
+	Platform string `json:"platform"`
+	Digest   string `json:"digest"`
+	Size     int64  `json:"size"`
+	Layers   int    `json:"layers"`
 }
 
 func (d BuildStatusData) MarshalBinary() ([]byte, error) {
@@ -93,6 +238,8 @@ const (
 	StatusBuilding
 	StatusSucceeded
 	StatusFailed
+	StatusCancelled
+	StatusTimedOut
 )
 
 func (status BuildStatus) String() string {
@@ -101,10 +248,17 @@ func (status BuildStatus) String() string {
 		StatusBuilding:  "building",
 		StatusSucceeded: "succeeded",
 		StatusFailed:    "failed",
+		StatusCancelled: "cancelled",
+		StatusTimedOut:  "timed_out",
 	}
 
-	if status < StatusPending || status > StatusFailed {
+	if status < StatusPending || status > StatusTimedOut {
 		return "unknown"
 	}
 	return statusStr[status]
 }
+
+// Finished reports whether the build reached a final status
+func (status BuildStatus) Finished() bool {
+	return status >= StatusSucceeded && status <= StatusTimedOut
+}
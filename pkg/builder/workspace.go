@@ -0,0 +1,240 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// workspaceMarker is created in every build workspace, so the sweeper
+	// never deletes the other directories of the workspace root
+	workspaceMarker = ".dockwiz-workspace"
+
+	workspaceSweepInterval = 10 * time.Minute
+	// workspaceSweepGrace keeps the workspaces which were just created,
+	// which may belong to a build not registered yet
+	workspaceSweepGrace = 10 * time.Minute
+
+	// diskCheckInterval is how often a paused worker checks the disk usage
+	diskCheckInterval = 10 * time.Second
+
+	// maxImageNameLength keeps the workspace of an image within the name
+	// limit of the filesystems
+	maxImageNameLength = 128
+)
+
+// imageNameRegex matches a single path component of a registry repository,
+// which names the workspace of the build
+var imageNameRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+
+// validateImageName checks the name of an image, which is used as the
+// directory of its workspace and must not escape the workspace root
+func validateImageName(name string) error {
+	if len(name) > maxImageNameLength {
+		return fmt.Errorf("image name exceeds %d characters", maxImageNameLength)
+	}
+	if !imageNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid image name %q: only lowercase letters, digits and separators are allowed", name)
+	}
+	return nil
+}
+
+// Health describes the state of a builder
+type Health struct {
+	Status        string  `json:"status"`          // ok, degraded when the workers are paused, unavailable without redis
+	Error         string  `json:"error,omitempty"` // why the builder is unavailable
+	DequeuePaused bool    `json:"dequeue_paused"`
+	DiskUsage     float64 `json:"disk_usage"`               // percent of the workspace filesystem in use
+	MaxDiskUsage  float64 `json:"max_disk_usage,omitempty"` // percent above which the workers are paused
+	ActiveBuilds  int     `json:"active_builds"`
+}
+
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// Health reports whether the builder can run builds
+func (b *Builder) Health() Health {
+	h := Health{
+		Status:       HealthOK,
+		MaxDiskUsage: b.maxDiskUsage,
+	}
+
+	b.activeMu.Lock()
+	h.ActiveBuilds = len(b.activeBuilds)
+	b.activeMu.Unlock()
+
+	usage, err := diskUsage(b.workspaceRoot)
+	if err != nil {
+		b.logger.Error("getting disk usage", zap.Error(err))
+	}
+	h.DiskUsage = usage
+	h.DequeuePaused = b.maxDiskUsage > 0 && usage >= b.maxDiskUsage
+	if h.DequeuePaused {
+		h.Status = HealthDegraded
+	}
+
+	if err := b.redisClient.Ping().Err(); err != nil {
+		h.Status = HealthUnavailable
+		h.Error = fmt.Sprintf("redis: %v", err)
+	}
+	return h
+}
+
+// newWorkspace creates the empty workspace of a build, and returns it with
+// a function deleting it once the build is done
+func (b *Builder) newWorkspace(imageName string) (string, func(), error) {
+	// The name is validated with the request, but the workspace is deleted
+	// recursively
+	if err := validateImageName(imageName); err != nil {
+		return "", nil, err
+	}
+	workspace := path.Join(b.workspaceRoot, imageName)
+
+	b.activeMu.Lock()
+	b.activeBuilds[imageName] = struct{}{}
+	b.activeMu.Unlock()
+
+	cleanup := func() {
+		if err := os.RemoveAll(workspace); err != nil {
+			b.logger.Error("deleting build workspace", zap.String("image_name", imageName), zap.Error(err))
+		}
+		b.activeMu.Lock()
+		delete(b.activeBuilds, imageName)
+		b.activeMu.Unlock()
+	}
+
+	// A workspace left by a previous attempt is started over
+	if err := os.RemoveAll(workspace); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.MkdirAll(workspace, 0755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.WriteFile(path.Join(workspace, workspaceMarker), nil, 0644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return workspace, cleanup, nil
+}
+
+// sweepWorkspaces deletes the workspaces no build of this instance uses,
+// e.g. left by a crash, once at start and then periodically until ctx is done
+func (b *Builder) sweepWorkspaces(ctx context.Context) {
+	ticker := time.NewTicker(workspaceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.deleteOrphanWorkspaces(time.Now()); err != nil {
+			b.logger.Error("deleting orphan workspaces", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Builder) deleteOrphanWorkspaces(now time.Time) error {
+	entries, err := os.ReadDir(b.workspaceRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := os.Stat(path.Join(b.workspaceRoot, e.Name(), workspaceMarker))
+		if err != nil || now.Sub(info.ModTime()) < workspaceSweepGrace {
+			continue
+		}
+
+		b.activeMu.Lock()
+		_, active := b.activeBuilds[e.Name()]
+		b.activeMu.Unlock()
+		if active {
+			continue
+		}
+
+		b.logger.Info("deleting orphan workspace", zap.String("name", e.Name()))
+		if err := os.RemoveAll(path.Join(b.workspaceRoot, e.Name())); err != nil {
+			b.logger.Error("deleting orphan workspace", zap.String("name", e.Name()), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// waitForDisk blocks while the disk usage is above the ceiling, and tells
+// if ctx is still running
+func (b *Builder) waitForDisk(ctx context.Context) bool {
+	if b.maxDiskUsage <= 0 {
+		return true
+	}
+
+	paused := false
+	for {
+		usage, err := diskUsage(b.workspaceRoot)
+		if err != nil {
+			// Builds are not blocked by a failing check
+			b.logger.Error("getting disk usage", zap.Error(err))
+			return true
+		}
+		if usage < b.maxDiskUsage {
+			if paused {
+				b.logger.Info("disk usage is back under the ceiling, resuming builds", zap.Float64("disk_usage", usage))
+			}
+			return true
+		}
+		if !paused {
+			b.logger.Warn("disk usage is above the ceiling, pausing builds", zap.Float64("disk_usage", usage), zap.Float64("max_disk_usage", b.maxDiskUsage))
+			paused = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(diskCheckInterval):
+		}
+	}
+}
+
+// diskUsage returns the percent of the filesystem holding dir which is in
+// use, like df does. dir may not exist yet.
+func diskUsage(dir string) (float64, error) {
+	dir = filepath.Clean(dir)
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(dir, &stat)
+		if err == nil {
+			used := stat.Blocks - stat.Bfree
+			total := used + stat.Bavail
+			if total == 0 {
+				return 0, nil
+			}
+			return float64(used) / float64(total) * 100, nil
+		}
+		if !errors.Is(err, syscall.ENOENT) || dir == filepath.Dir(dir) {
+			return 0, err
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateImageName(t *testing.T) {
+	for _, name := range []string{"app", "my-image", "bittwister-nightly", "a1.b_c__d", "artifact-" + uuid.NewString()} {
+		assert.NoError(t, validateImageName(name), name)
+	}
+	for _, name := range []string{"", "..", "../etc", "/etc", "org/app", "App", "-app", "app-", "a..b", "a b", strings.Repeat("a", maxImageNameLength+1)} {
+		assert.Error(t, validateImageName(name), name)
+	}
+
+	b, _ := newTestBuilder(t)
+	_, err := b.AddToBuildQueue(BuilderOptions{
+		Git:   GitOptions{URL: "github.com/test-username/test-repo"},
+		Image: ImageOptions{Name: "../../etc"},
+	})
+	assert.Error(t, err, "The image name should not escape the workspace root")
+	_, _, err = b.newWorkspace("..")
+	assert.Error(t, err)
+}
+
+func TestDeleteOrphanWorkspaces(t *testing.T) {
+	b, _ := newTestBuilder(t)
+
+	active, cleanup, err := b.newWorkspace("active")
+	require.NoError(t, err)
+	defer cleanup()
+	orphan, orphanCleanup, err := b.newWorkspace("orphan")
+	require.NoError(t, err)
+	// The orphan is left behind by a crashed build
+	b.activeMu.Lock()
+	delete(b.activeBuilds, "orphan")
+	b.activeMu.Unlock()
+	unknown := filepath.Join(b.workspaceRoot, "unknown")
+	require.NoError(t, os.Mkdir(unknown, 0755))
+
+	require.NoError(t, b.deleteOrphanWorkspaces(time.Now()))
+	assert.DirExists(t, orphan, "Recent workspaces should be kept")
+
+	later := time.Now().Add(workspaceSweepGrace + time.Minute)
+	require.NoError(t, b.deleteOrphanWorkspaces(later))
+	assert.NoDirExists(t, orphan, "Orphan workspaces should be deleted")
+	assert.DirExists(t, active, "Workspaces of running builds should be kept")
+	assert.DirExists(t, unknown, "Directories which are not workspaces should be kept")
+
+	orphanCleanup()
+	cleanup()
+	assert.NoDirExists(t, active)
+	assert.Zero(t, b.Health().ActiveBuilds)
+}
+
+func TestDiskCeiling(t *testing.T) {
+	usage, err := diskUsage(filepath.Join(t.TempDir(), "not", "created"))
+	require.NoError(t, err)
+	assert.Greater(t, usage, 0.0)
+	assert.LessOrEqual(t, usage, 100.0)
+
+	b, _ := newTestBuilder(t)
+	assert.True(t, b.waitForDisk(context.Background()), "Without a ceiling the workers should never pause")
+	health := b.Health()
+	assert.Equal(t, HealthOK, health.Status)
+	assert.False(t, health.DequeuePaused)
+
+	b, _ = newTestBuilder(t, WithMaxDiskUsage(usage/2))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, b.waitForDisk(ctx), "The worker should stop waiting once stopped")
+	health = b.Health()
+	assert.Equal(t, HealthDegraded, health.Status)
+	assert.True(t, health.DequeuePaused)
+}
@@ -0,0 +1,70 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// Credential holds the secrets needed to access a registry or a git
+// repository. Git repositories are cloned over SSH when SSHPrivateKey is set,
+// and over HTTPS with the token or the username and password otherwise.
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // also the passphrase of SSHPrivateKey
+	Token    string `json:"token,omitempty"`
+
+	SSHPrivateKey string `json:"ssh_private_key,omitempty"` // PEM encoded
+	SSHKnownHosts string `json:"ssh_known_hosts,omitempty"` // known_hosts file content
+}
+
+// Secrets returns the secret values of the credential, e.g. to redact them
+func (c Credential) Secrets() []string {
+	var secrets []string
+	for _, s := range []string{c.Password, c.Token, c.SSHPrivateKey} {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// Store keeps the credentials available to the builds, indexed by name.
+// Build requests only carry the name of a credential, never its content.
+type Store struct {
+	creds map[string]Credential
+}
+
+func NewStore(creds map[string]Credential) *Store {
+	if creds == nil {
+		creds = map[string]Credential{}
+	}
+	return &Store{creds: creds}
+}
+
+// LoadFile reads the credentials from a JSON file in the following format:
+//
+//	{"ghcr": {"username": "bot", "password": "token"}}
+func LoadFile(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials file: %w", err)
+	}
+
+	var creds map[string]Credential
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parsing credentials file: %w", err)
+	}
+	return NewStore(creds), nil
+}
+
+func (s *Store) Get(name string) (Credential, error) {
+	cred, ok := s.creds[name]
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+	}
+	return cred, nil
+}
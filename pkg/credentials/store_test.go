@@ -0,0 +1,33 @@
+package credentials_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	err := os.WriteFile(path, []byte(`{"ghcr": {"username": "bot", "password": "token"}}`), 0600)
+	require.NoError(t, err, "Error should be nil when writing the credentials file")
+
+	store, err := credentials.LoadFile(path)
+	require.NoError(t, err, "Error should be nil when loading the credentials file")
+
+	cred, err := store.Get("ghcr")
+	require.NoError(t, err, "Error should be nil when getting an existing credential")
+	assert.Equal(t, credentials.Credential{Username: "bot", Password: "token"}, cred, "Credential should match")
+
+	_, err = store.Get("missing")
+	assert.ErrorIs(t, err, credentials.ErrCredentialNotFound, "Error should be ErrCredentialNotFound")
+}
+
+func TestCredentialSecrets(t *testing.T) {
+	cred := credentials.Credential{Username: "bot", Token: "token", SSHPrivateKey: "key"}
+	assert.Equal(t, []string{"token", "key"}, cred.Secrets(), "Only the secret values should be returned")
+	assert.Empty(t, credentials.Credential{Username: "bot"}.Secrets())
+}
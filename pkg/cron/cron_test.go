@@ -0,0 +1,75 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/celestiaorg/dockwiz/pkg/cron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every 5m",
+	} {
+		_, err := cron.Parse(expr)
+		assert.Error(t, err, "%q should not parse", expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A wednesday
+	from := time.Date(2024, time.January, 10, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 10, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 10, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, time.January, 11, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, time.January, 11, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 10, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, time.January, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1-7/3 jun *", time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"5,50 10 * * *", time.Date(2024, time.January, 10, 10, 50, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 20 * fri", time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := cron.Parse(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, s.Next(from), tt.expr)
+	}
+
+	never, err := cron.Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, never.Next(from).IsZero(), "Expressions which never match should have no next time")
+}
+
+func TestNextLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	s, err := cron.Parse("0 2 * * *")
+	require.NoError(t, err)
+	next := s.Next(time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC).In(loc))
+	assert.Equal(t, time.Date(2024, time.January, 11, 1, 0, 0, 0, time.UTC), next.UTC(), "Times should be in the location of the given time")
+}
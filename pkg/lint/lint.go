@@ -0,0 +1,136 @@
+package lint
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+type Severity string
+
+// The severities match the message types of the API
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// RuleSyntax reports the Dockerfiles the BuildKit parser rejects or warns about
+const RuleSyntax = "syntax"
+
+// Violation is a problem a rule found in a Dockerfile
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Title    string   `json:"title"`
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line,omitempty"` // 0 when it applies to the whole Dockerfile
+	Message  string   `json:"message"`
+}
+
+// Options describe the build a Dockerfile is linted for
+type Options struct {
+	BuildArgs []string // names of the build args given to the build
+	Target    string   // stage to build, the last one if empty
+}
+
+// Dockerfile is a parsed Dockerfile, as seen by the rules
+type Dockerfile struct {
+	Stages   []instructions.Stage
+	MetaArgs []instructions.ArgCommand // ARG instructions before the first FROM
+	Options  Options
+}
+
+// Rule checks a Dockerfile
+type Rule interface {
+	Check(df *Dockerfile) []Violation
+}
+
+// Linter checks Dockerfiles against a set of rules
+type Linter struct {
+	rules []Rule
+}
+
+func NewLinter(rules ...Rule) *Linter {
+	return &Linter{rules: rules}
+}
+
+// Lint parses a Dockerfile and returns the violations of the rules, sorted
+// by line. A Dockerfile which cannot be parsed has a single violation.
+func (l *Linter) Lint(dockerfile []byte, opts Options) []Violation {
+	res, err := parser.Parse(bytes.NewReader(dockerfile))
+	if err != nil {
+		return []Violation{syntaxError(err)}
+	}
+
+	var violations []Violation
+	for _, w := range res.Warnings {
+		v := Violation{
+			Rule:     RuleSyntax,
+			Title:    "syntax warning",
+			Severity: SeverityWarning,
+			Message:  w.Short,
+		}
+		if w.Location != nil {
+			v.Line = w.Location.Start.Line
+		}
+		violations = append(violations, v)
+	}
+
+	stages, metaArgs, err := instructions.Parse(res.AST)
+	if err != nil {
+		return append(violations, syntaxError(err))
+	}
+	if len(stages) == 0 {
+		return append(violations, Violation{
+			Rule:     RuleSyntax,
+			Title:    "syntax error",
+			Severity: SeverityError,
+			Message:  "the Dockerfile has no FROM instruction",
+		})
+	}
+
+	df := &Dockerfile{Stages: stages, MetaArgs: metaArgs, Options: opts}
+	for _, rule := range l.rules {
+		violations = append(violations, rule.Check(df)...)
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Line < violations[j].Line
+	})
+	return violations
+}
+
+// HasErrors tells if any of the violations is an error
+func HasErrors(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func syntaxError(err error) Violation {
+	v := Violation{
+		Rule:     RuleSyntax,
+		Title:    "syntax error",
+		Severity: SeverityError,
+		Message:  err.Error(),
+	}
+	var el *parser.ErrorLocation
+	if errors.As(err, &el) {
+		v.Line = line(el.Location)
+	}
+	return v
+}
+
+// line returns the first line of a location
+func line(location []parser.Range) int {
+	if len(location) == 0 {
+		return 0
+	}
+	return location[0].Start.Line
+}
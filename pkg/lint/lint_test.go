@@ -0,0 +1,114 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/celestiaorg/dockwiz/pkg/lint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		opts       lint.Options
+		want       []lint.Violation
+	}{
+		{
+			name:       "clean",
+			dockerfile: "ARG VERSION=3.19\nFROM golang:1.21 AS build\nRUN go build\nFROM alpine:${VERSION}\nCOPY --from=build /app /app\nUSER app\n",
+		},
+		{
+			name:       "syntax error",
+			dockerfile: "FROM alpine:3.19\nCOPY\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleSyntax, Severity: lint.SeverityError, Line: 2},
+			},
+		},
+		{
+			name:       "no FROM",
+			dockerfile: "RUN true\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleSyntax, Severity: lint.SeverityError, Line: 1},
+			},
+		},
+		{
+			name:       "unpinned base images",
+			dockerfile: "FROM golang AS build\nFROM alpine:latest\nFROM build\nFROM registry:5000/app@sha256:0000000000000000000000000000000000000000000000000000000000000000\nUSER app\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleUnpinnedBaseImage, Severity: lint.SeverityWarning, Line: 1},
+				{Rule: lint.RuleUnpinnedBaseImage, Severity: lint.SeverityWarning, Line: 2},
+			},
+		},
+		{
+			name:       "missing user",
+			dockerfile: "FROM alpine:3.19\nRUN true\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleMissingUser, Severity: lint.SeverityWarning, Line: 1},
+			},
+		},
+		{
+			name:       "root user",
+			dockerfile: "FROM alpine:3.19\nUSER app\nUSER root:root\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleMissingUser, Severity: lint.SeverityWarning, Line: 3},
+			},
+		},
+		{
+			name:       "user of the base stage",
+			dockerfile: "FROM alpine:3.19 AS base\nUSER 1000\nFROM base\nRUN true\n",
+		},
+		{
+			name:       "target stage",
+			dockerfile: "FROM alpine:3.19 AS dev\nFROM alpine:3.19\nUSER app\n",
+			opts:       lint.Options{Target: "dev"},
+			want: []lint.Violation{
+				{Rule: lint.RuleMissingUser, Severity: lint.SeverityWarning, Line: 1},
+			},
+		},
+		{
+			name:       "remote add",
+			dockerfile: "FROM alpine:3.19\nADD https://example.com/app.tar.gz local.txt /app/\nUSER app\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleRemoteAdd, Severity: lint.SeverityWarning, Line: 2},
+			},
+		},
+		{
+			name:       "unknown args",
+			dockerfile: "ARG BASE\nFROM ${BASE}:${VERSION}\nFROM --platform=$BUILDPLATFORM alpine:3.19\nARG GOOS\nUSER app\n",
+			opts:       lint.Options{BuildArgs: []string{"BASE", "GOOS", "HTTP_PROXY", "NAME"}},
+			want: []lint.Violation{
+				{Rule: lint.RuleUnknownArg, Severity: lint.SeverityWarning},
+				{Rule: lint.RuleUnknownArg, Severity: lint.SeverityError, Line: 2},
+			},
+		},
+		{
+			name:       "args with defaults",
+			dockerfile: "FROM ${IMG:-alpine:3.19}\nFROM ${BASE-alpine}:${TAG}\nUSER app\n",
+			want: []lint.Violation{
+				{Rule: lint.RuleUnknownArg, Severity: lint.SeverityError, Line: 2},
+			},
+		},
+	}
+
+	linter := lint.NewLinter(lint.DefaultRules()...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := linter.Lint([]byte(tt.dockerfile), tt.opts)
+			require.Len(t, violations, len(tt.want), "%+v", violations)
+			for i, v := range violations {
+				assert.Equal(t, tt.want[i].Rule, v.Rule)
+				assert.Equal(t, tt.want[i].Severity, v.Severity)
+				assert.Equal(t, tt.want[i].Line, v.Line)
+				assert.NotEmpty(t, v.Message)
+			}
+		})
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	assert.False(t, lint.HasErrors(nil))
+	assert.False(t, lint.HasErrors([]lint.Violation{{Severity: lint.SeverityWarning}}))
+	assert.True(t, lint.HasErrors([]lint.Violation{{Severity: lint.SeverityWarning}, {Severity: lint.SeverityError}}))
+}
@@ -0,0 +1,287 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+)
+
+// Names of the default rules
+const (
+	RuleUnpinnedBaseImage = "unpinned-base-image"
+	RuleMissingUser       = "missing-user"
+	RuleRemoteAdd         = "remote-add"
+	RuleUnknownArg        = "unknown-arg"
+)
+
+// predefinedArgs can be used without being declared, see
+// https://docs.docker.com/engine/reference/builder/#predefined-args
+var predefinedArgs = map[string]bool{
+	"HTTP_PROXY": true, "http_proxy": true,
+	"HTTPS_PROXY": true, "https_proxy": true,
+	"FTP_PROXY": true, "ftp_proxy": true,
+	"NO_PROXY": true, "no_proxy": true,
+	"ALL_PROXY": true, "all_proxy": true,
+	"TARGETPLATFORM": true, "TARGETOS": true, "TARGETARCH": true, "TARGETVARIANT": true,
+	"BUILDPLATFORM": true, "BUILDOS": true, "BUILDARCH": true, "BUILDVARIANT": true,
+}
+
+// varRegex matches $VAR and ${VAR}, and the default of ${VAR:-default} and
+// ${VAR-default}
+var varRegex = regexp.MustCompile(`\$(\{)?([A-Za-z_][A-Za-z0-9_]*)(:?-)?`)
+
+// requiredVars returns the variables of a word which have no default
+func requiredVars(word string) []string {
+	var vars []string
+	for _, m := range varRegex.FindAllStringSubmatch(word, -1) {
+		if m[1] != "" && m[3] != "" {
+			continue
+		}
+		vars = append(vars, m[2])
+	}
+	return vars
+}
+
+// DefaultRules returns the rules dockwiz lints Dockerfiles with
+func DefaultRules() []Rule {
+	return []Rule{
+		UnpinnedBaseImage{},
+		MissingUser{},
+		RemoteAdd{},
+		UnknownArg{},
+	}
+}
+
+// UnpinnedBaseImage warns about base images without a tag, or with the
+// latest tag, as they change from one build to another.
+type UnpinnedBaseImage struct{}
+
+func (UnpinnedBaseImage) Check(df *Dockerfile) []Violation {
+	args := metaArgDefaults(df.MetaArgs)
+	stages := map[string]bool{}
+
+	var violations []Violation
+	for _, s := range df.Stages {
+		base, ok := expand(s.BaseName, args)
+		switch {
+		case !ok:
+			// depends on a build arg, it cannot be known before the build
+		case base == "scratch", stages[strings.ToLower(base)]:
+		case !strings.Contains(base, "@") && imageTag(base) == "":
+			violations = append(violations, unpinnedBaseImage(s, base, "has no tag"))
+		case !strings.Contains(base, "@") && imageTag(base) == "latest":
+			violations = append(violations, unpinnedBaseImage(s, base, "uses the latest tag"))
+		}
+		if s.Name != "" {
+			stages[strings.ToLower(s.Name)] = true
+		}
+	}
+	return violations
+}
+
+func unpinnedBaseImage(s instructions.Stage, base, reason string) Violation {
+	return Violation{
+		Rule:     RuleUnpinnedBaseImage,
+		Title:    "unpinned base image",
+		Severity: SeverityWarning,
+		Line:     line(s.Location),
+		Message:  fmt.Sprintf("base image %q %s, pin it to a version or a digest", base, reason),
+	}
+}
+
+// imageTag returns the tag of an image reference, if any
+func imageTag(ref string) string {
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// MissingUser warns when the built stage runs as root, either because it
+// never sets USER or because it sets it to root.
+type MissingUser struct{}
+
+func (MissingUser) Check(df *Dockerfile) []Violation {
+	stage, ok := targetStage(df)
+	if !ok {
+		return nil
+	}
+
+	byName := map[string]instructions.Stage{}
+	for _, s := range df.Stages {
+		if s.Name != "" {
+			byName[strings.ToLower(s.Name)] = s
+		}
+	}
+
+	// the USER of a stage is inherited by the stages built on it
+	seen := map[string]bool{}
+	for s := stage; ; {
+		if user := lastUser(s); user != nil {
+			name := strings.SplitN(user.User, ":", 2)[0]
+			if name != "root" && name != "0" {
+				return nil
+			}
+			return []Violation{{
+				Rule:     RuleMissingUser,
+				Title:    "image runs as root",
+				Severity: SeverityWarning,
+				Line:     line(user.Location()),
+				Message:  "the image runs as root, set USER to an unprivileged user",
+			}}
+		}
+
+		base, ok := byName[strings.ToLower(s.BaseName)]
+		if !ok || seen[strings.ToLower(s.BaseName)] {
+			break
+		}
+		seen[strings.ToLower(s.BaseName)] = true
+		s = base
+	}
+
+	return []Violation{{
+		Rule:     RuleMissingUser,
+		Title:    "missing USER",
+		Severity: SeverityWarning,
+		Line:     line(stage.Location),
+		Message:  "the image does not set USER and may run as root",
+	}}
+}
+
+func lastUser(s instructions.Stage) *instructions.UserCommand {
+	for i := len(s.Commands) - 1; i >= 0; i-- {
+		if user, ok := s.Commands[i].(*instructions.UserCommand); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// targetStage returns the stage the build produces
+func targetStage(df *Dockerfile) (instructions.Stage, bool) {
+	if df.Options.Target == "" {
+		return df.Stages[len(df.Stages)-1], true
+	}
+	for _, s := range df.Stages {
+		if strings.EqualFold(s.Name, df.Options.Target) {
+			return s, true
+		}
+	}
+	return instructions.Stage{}, false
+}
+
+// RemoteAdd warns about ADD instructions downloading URLs, which are not
+// verified and are better done with curl or wget in a RUN instruction.
+type RemoteAdd struct{}
+
+func (RemoteAdd) Check(df *Dockerfile) []Violation {
+	var violations []Violation
+	for _, s := range df.Stages {
+		for _, cmd := range s.Commands {
+			add, ok := cmd.(*instructions.AddCommand)
+			if !ok {
+				continue
+			}
+			for _, src := range add.SourcePaths {
+				if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+					continue
+				}
+				violations = append(violations, Violation{
+					Rule:     RuleRemoteAdd,
+					Title:    "ADD of a remote URL",
+					Severity: SeverityWarning,
+					Line:     line(add.Location()),
+					Message:  fmt.Sprintf("ADD downloads %s without verifying it, use RUN with curl or wget instead", src),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// UnknownArg reports the variables used in FROM which are not declared by an
+// ARG before it, which fails the build, and the build args which are not
+// declared by any ARG, which are ignored.
+type UnknownArg struct{}
+
+func (UnknownArg) Check(df *Dockerfile) []Violation {
+	meta := map[string]bool{}
+	for _, arg := range df.MetaArgs {
+		for _, kv := range arg.Args {
+			meta[kv.Key] = true
+		}
+	}
+
+	var violations []Violation
+	for _, s := range df.Stages {
+		for _, name := range requiredVars(s.BaseName) {
+			if meta[name] || predefinedArgs[name] {
+				continue
+			}
+			violations = append(violations, Violation{
+				Rule:     RuleUnknownArg,
+				Title:    "unknown ARG",
+				Severity: SeverityError,
+				Line:     line(s.Location),
+				Message:  fmt.Sprintf("FROM uses %s which is not declared by an ARG before the first FROM", name),
+			})
+		}
+	}
+
+	declared := map[string]bool{}
+	for k := range meta {
+		declared[k] = true
+	}
+	for _, s := range df.Stages {
+		for _, cmd := range s.Commands {
+			if arg, ok := cmd.(*instructions.ArgCommand); ok {
+				for _, kv := range arg.Args {
+					declared[kv.Key] = true
+				}
+			}
+		}
+	}
+	for _, name := range df.Options.BuildArgs {
+		if declared[name] || predefinedArgs[name] {
+			continue
+		}
+		violations = append(violations, Violation{
+			Rule:     RuleUnknownArg,
+			Title:    "unused build arg",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("build arg %s is not declared by any ARG and is ignored", name),
+		})
+	}
+	return violations
+}
+
+func metaArgDefaults(metaArgs []instructions.ArgCommand) map[string]string {
+	args := map[string]string{}
+	for _, arg := range metaArgs {
+		for _, kv := range arg.Args {
+			if kv.Value != nil {
+				args[kv.Key] = *kv.Value
+			}
+		}
+	}
+	return args
+}
+
+// expand replaces the variables of a word with their values, it fails if
+// one of them has neither a value nor a default.
+func expand(word string, args map[string]string) (string, bool) {
+	for _, name := range requiredVars(word) {
+		if _, ok := args[name]; !ok {
+			return "", false
+		}
+	}
+	expanded, err := shell.NewLex('\\').ProcessWordWithMap(word, args)
+	if err != nil {
+		return "", false
+	}
+	return expanded, true
+}
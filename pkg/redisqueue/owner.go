@@ -0,0 +1,119 @@
+package redisqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Items are grouped by owner, e.g. a team or a repository, in each lane: a
+// lane has a list per owner and a ring of the owners with items, served
+// round-robin, so an owner queuing many items does not hold back the others.
+// The items of the default owner "" are in the list of the lane itself,
+// along the items queued before owners existed.
+//
+// Items held outside of the owner lists, i.e. delayed or reserved ones, are
+// stored tagged with their owner (see ownerLua).
+//
+// The owner lists, rings and running sets are named inside the scripts, from
+// the owners they read, so the scripts touch keys they are not given in KEYS.
+// This requires a standalone Redis (or a primary with replicas): a Redis
+// Cluster would reject the scripts, as their keys may be in other slots.
+
+// SetOwnerLimits bounds how many items of an owner are reserved at once, to
+// limit, or to limits[owner] if set. An owner at its limit is skipped until
+// one of its leases is acknowledged or reaped. A limit of 0 means no limit.
+// Limits only apply to Reserve, as dequeued items are not tracked.
+func (q *Queue) SetOwnerLimits(limit int, limits map[string]int) {
+	q.ownerLimit = max(limit, 0)
+	q.ownerLimits = "{}"
+	if len(limits) > 0 {
+		b, _ := json.Marshal(limits)
+		q.ownerLimits = string(b)
+	}
+}
+
+// hasOwnerLimits tells whether the reserved items of any owner are limited
+func (q *Queue) hasOwnerLimits() bool {
+	return q.ownerLimit > 0 || q.ownerLimits != "{}"
+}
+
+// runningPrefix prefixes the sorted set of the consumers with a reserved
+// item of an owner, scored like their lease
+func (q *Queue) runningPrefix() string {
+	return q.name + ":running:"
+}
+
+func validateOwner(owner string) error {
+	if strings.ContainsRune(owner, 0) {
+		return fmt.Errorf("invalid owner %q", owner)
+	}
+	return nil
+}
+
+// untag splits an item stored with its owner, see ownerLua
+func untag(stored string) (owner, item string) {
+	if !strings.HasPrefix(stored, "\x00") {
+		return "", stored
+	}
+	owner, item, _ = strings.Cut(stored[1:], "\x00")
+	return owner, item
+}
+
+// ownerLua defines the helpers of the owner lists of the lanes:
+//   - tag(owner, item) prefixes the item with "\0owner\0", unless the owner
+//     is the default one, and untag(stored) splits it
+//   - push(lane, stored, front) adds an item to the end, or the head if
+//     front, of the list of its owner, and adds the owner to the ring of the
+//     lane if it was not in it
+const ownerLua = `
+local function tag(owner, item)
+	if owner == '' then
+		return item
+	end
+	return '\0' .. owner .. '\0' .. item
+end
+
+local function untag(stored)
+	if string.byte(stored, 1) ~= 0 then
+		return '', stored
+	end
+	local sep = string.find(stored, '\0', 2, true)
+	return string.sub(stored, 2, sep - 1), string.sub(stored, sep + 1)
+end
+
+local function owner_list(lane, owner)
+	if owner == '' then
+		return lane
+	end
+	return lane .. ':owner:' .. owner
+end
+
+local function push(lane, stored, front)
+	local owner, item = untag(stored)
+	local cmd = 'RPUSH'
+	if front then
+		cmd = 'LPUSH'
+	end
+	redis.call(cmd, owner_list(lane, owner), item)
+	if redis.call('SADD', lane .. ':owners', owner) == 1 then
+		redis.call(cmd, lane .. ':ring', owner)
+	end
+end
+`
+
+// ownerListNames returns the owner lists of a lane
+func (q *Queue) ownerListNames(lane string) ([]string, error) {
+	owners, err := q.client.SMembers(lane + ":owners").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{lane}
+	for _, owner := range owners {
+		if owner != "" {
+			names = append(names, lane+":owner:"+owner)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,158 @@
+package redisqueue
+
+import "fmt"
+
+// Priority selects the lane an item is queued in. Items of a higher
+// priority lane are dequeued first, but a lane skipped too many times in a
+// row while it had items is served next, so lower lanes are never starved.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// priorities lists the lanes from the highest priority to the lowest
+var priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// DefaultStarvationLimit is how many items of higher lanes are dequeued in a
+// row, by default, while a lane has items, before it is served
+const DefaultStarvationLimit = 10
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// laneName is the list of the items of a priority. The normal lane is the
+// list of the queue name, which holds the items queued before lanes existed.
+func (q *Queue) laneName(p Priority) string {
+	switch p {
+	case PriorityHigh:
+		return q.name + ":high"
+	case PriorityLow:
+		return q.name + ":low"
+	}
+	return q.name
+}
+
+// laneNames returns the lists of the lanes from the highest priority to the
+// lowest
+func (q *Queue) laneNames() []string {
+	names := make([]string, 0, len(priorities))
+	for _, p := range priorities {
+		names = append(names, q.laneName(p))
+	}
+	return names
+}
+
+// skipsName is the hash of how many times in a row each lane was skipped
+// while it had items
+func (q *Queue) skipsName() string {
+	return q.name + ":skips"
+}
+
+// SetStarvationLimit sets how many items of higher lanes are dequeued in a
+// row while a lane has items, before it is served. It must be at least 1.
+func (q *Queue) SetStarvationLimit(n int) {
+	q.starvationLimit = max(n, 1)
+}
+
+// popArgs returns the arguments of popLua for consumer, whose items of an
+// owner are counted as running until deadline
+func (q *Queue) popArgs(now int64, consumer string, deadline int64) []interface{} {
+	return []interface{}{q.starvationLimit, now, q.ownerLimit, q.ownerLimits, q.runningPrefix(), consumer, deadline}
+}
+
+// popLua defines pop(first), which pops the next item of the lanes
+// KEYS[first..], ordered from the highest priority, whose skips are counted
+// in the hash KEYS[first-1], and returns it tagged with its owner. A lane
+// skipped ARGV[1] times is served first, the lowest one if several are.
+// Within a lane, the owners are served round-robin. The arguments are those
+// of popArgs: when a consumer is given, an owner with as many running items
+// as its limit is skipped.
+const popLua = ownerLua + `
+local starvation = tonumber(ARGV[1])
+local now = ARGV[2]
+local default_limit = tonumber(ARGV[3])
+local limits = cjson.decode(ARGV[4])
+local running = ARGV[5]
+local consumer = ARGV[6]
+local deadline = ARGV[7]
+
+local function at_limit(owner)
+	if consumer == '' then
+		return false
+	end
+	local limit = tonumber(limits[owner] or default_limit)
+	if limit <= 0 then
+		return false
+	end
+	return redis.call('ZCOUNT', running .. owner, '(' .. now, '+inf') >= limit
+end
+
+local function has_items(lane)
+	return redis.call('LLEN', lane .. ':ring') > 0 or redis.call('LLEN', lane) > 0
+end
+
+local function pop_lane(lane)
+	local ring = lane .. ':ring'
+	for _ = 1, redis.call('LLEN', ring) do
+		local owner = redis.call('LPOP', ring)
+		if at_limit(owner) then
+			redis.call('RPUSH', ring, owner)
+		else
+			local list = owner_list(lane, owner)
+			local item = redis.call('LPOP', list)
+			if redis.call('LLEN', list) > 0 then
+				redis.call('RPUSH', ring, owner)
+			else
+				redis.call('SREM', lane .. ':owners', owner)
+			end
+			if item then
+				return tag(owner, item)
+			end
+		end
+	end
+	-- items queued before owners existed are in no ring
+	if not at_limit('') then
+		return redis.call('LPOP', lane)
+	end
+	return false
+end
+
+local function pop(first)
+	local skips = KEYS[first - 1]
+	for i = #KEYS, first + 1, -1 do
+		local skipped = tonumber(redis.call('HGET', skips, KEYS[i]) or '0')
+		if skipped >= starvation then
+			redis.call('HDEL', skips, KEYS[i])
+			local item = pop_lane(KEYS[i])
+			if item then
+				return item
+			end
+		end
+	end
+	for i = first, #KEYS do
+		local item = pop_lane(KEYS[i])
+		if item then
+			for j = i + 1, #KEYS do
+				if has_items(KEYS[j]) then
+					redis.call('HINCRBY', skips, KEYS[j], 1)
+				end
+			end
+			redis.call('HDEL', skips, KEYS[i])
+			return item
+		end
+	end
+	return false
+end
+`
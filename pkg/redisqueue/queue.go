@@ -1,15 +1,20 @@
 package redisqueue
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-redis/redis"
 )
 
 type Queue struct {
-	client *redis.Client
-	name   string
+	client          *redis.Client
+	name            string
+	starvationLimit int
+	ownerLimit      int
+	ownerLimits     string // JSON object of the limits of the owners
 }
 
 var (
@@ -18,42 +23,244 @@ var (
 
 func NewQueue(client *redis.Client, name string) *Queue {
 	return &Queue{
-		client: client,
-		name:   name,
+		client:          client,
+		name:            name,
+		starvationLimit: DefaultStarvationLimit,
+		ownerLimits:     "{}",
 	}
 }
 
+const (
+	// waitTimeout bounds a single wait for a notification, so items queued
+	// without one, e.g. by an older version, are still picked up
+	waitTimeout = 5 * time.Second
+
+	// maxNotifications bounds the notifications pending in the notify list
+	maxNotifications = 1000
+)
+
+// notifyName is the list a notification is pushed to for every item added
+// to the queue, which waiting consumers block on. Notifications are only
+// hints: a consumer which finds the queue empty waits again.
+func (q *Queue) notifyName() string {
+	return q.name + ":notify"
+}
+
+// Enqueue adds an item to the end of the normal lane
 func (q *Queue) Enqueue(item interface{}) error {
-	tx := q.client.TxPipeline()
-	if err := tx.RPush(q.name, item).Err(); err != nil {
+	return q.EnqueuePriority(item, PriorityNormal)
+}
+
+// EnqueuePriority adds an item of the default owner to the end of the lane
+// of priority p
+func (q *Queue) EnqueuePriority(item interface{}, p Priority) error {
+	return q.EnqueueOwner(item, p, "")
+}
+
+// enqueueScript adds the item ARGV[1] of owner ARGV[2] to the end of the
+// lane KEYS[1], and notifies KEYS[2] of it, which keeps its ARGV[3] last
+// notifications
+var enqueueScript = redis.NewScript(ownerLua + `
+push(KEYS[1], tag(ARGV[2], ARGV[1]), false)
+redis.call('RPUSH', KEYS[2], 1)
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[3]), -1)
+return 1
+`)
+
+// EnqueueOwner adds an item of owner to the end of its list in the lane of
+// priority p
+func (q *Queue) EnqueueOwner(item interface{}, p Priority, owner string) error {
+	if err := validateOwner(owner); err != nil {
 		return fmt.Errorf("enqueue error: %v", err)
 	}
 
-	if _, err := tx.Exec(); err != nil {
+	keys := []string{q.laneName(p), q.notifyName()}
+	if err := enqueueScript.Run(q.client, keys, item, owner, maxNotifications).Err(); err != nil {
 		return fmt.Errorf("enqueue error: %v", err)
 	}
 	return nil
 }
 
+// dequeueScript pops the next item of the lanes KEYS[2..], see popLua
+var dequeueScript = redis.NewScript(popLua + `
+return pop(2)
+`)
+
+// Dequeue removes the next item from the queue: the head of the list of the
+// next owner of the highest priority lane with items, unless a lower lane
+// was starved
 func (q *Queue) Dequeue(data interface{}) error {
-	tx := q.client.TxPipeline()
-	if err := tx.LPop(q.name).Err(); err != nil {
-		return fmt.Errorf("dequeue error `tx.LPop`: %v", err)
+	keys := append([]string{q.skipsName()}, q.laneNames()...)
+	stored, err := dequeueScript.Run(q.client, keys, q.popArgs(time.Now().UnixMilli(), "", 0)...).String()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrQueueEmpty
+		}
+		return fmt.Errorf("dequeue error: %v", err)
 	}
-	cmds, err := tx.Exec()
-	if err != nil && err != redis.Nil {
-		return fmt.Errorf("dequeue error `tx.Exec`: %v", err)
+
+	_, item := untag(stored)
+	if err := redis.NewStringResult(item, nil).Scan(data); err != nil {
+		return fmt.Errorf("error converting result: %v", err)
 	}
+	return nil
+}
 
-	if len(cmds) != 1 {
-		return fmt.Errorf("dequeue error: expected 1 command, got %d", len(cmds))
+// DequeueContext is Dequeue, but it waits for an item until ctx is done,
+// in which case it returns the error of ctx
+func (q *Queue) DequeueContext(ctx context.Context, data interface{}) error {
+	for {
+		err := q.Dequeue(data)
+		if err != ErrQueueEmpty {
+			return err
+		}
+		if err := q.wait(ctx); err != nil {
+			return err
+		}
 	}
+}
 
-	if err := cmds[0].(*redis.StringCmd).Scan(data); err != nil {
+// wait blocks until an item may have been added to the queue, waitTimeout
+// elapsed or ctx is done
+func (q *Queue) wait(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		err := q.client.BLPop(waitTimeout, q.notifyName()).Err()
 		if err == redis.Nil {
-			return ErrQueueEmpty
+			err = nil
 		}
-		return fmt.Errorf("error converting result: %v", err)
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		// The blocking call cannot be interrupted, so it is woken up by a
+		// notification rather than left holding its connection until it times
+		// out. Another consumer may take it, which then simply waits again.
+		if err := q.client.RPush(q.notifyName(), 1).Err(); err == nil {
+			<-done
+		}
+		return ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("wait error: %v", err)
+		}
+		return nil
+	}
+}
+
+// promoteScript moves up to ARGV[2] items of the delayed set KEYS[1] whose
+// time ARGV[1] has come to the end of the lane KEYS[2], and notifies KEYS[3]
+// of each of them
+var promoteScript = redis.NewScript(ownerLua + `
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, stored in ipairs(items) do
+	push(KEYS[2], stored, false)
+	redis.call('RPUSH', KEYS[3], 1)
+	redis.call('ZREM', KEYS[1], stored)
+end
+return #items
+`)
+
+// promoteBatchSize bounds the items moved by a single PromoteDue call
+const promoteBatchSize = 100
+
+// delayedName is the sorted set of the items enqueued with a delay in the
+// lane of priority p, tagged with their owner and scored by the unix time in
+// milliseconds they are due at
+func (q *Queue) delayedName(p Priority) string {
+	return q.laneName(p) + ":delayed"
+}
+
+// EnqueueAt adds an item to the normal lane, see EnqueueAtOwner
+func (q *Queue) EnqueueAt(item interface{}, at time.Time) error {
+	return q.EnqueueAtPriority(item, at, PriorityNormal)
+}
+
+// EnqueueAtPriority adds an item of the default owner to the lane of
+// priority p, see EnqueueAtOwner
+func (q *Queue) EnqueueAtPriority(item interface{}, at time.Time, p Priority) error {
+	return q.EnqueueAtOwner(item, at, p, "")
+}
+
+// enqueueAtScript adds the item ARGV[2] of owner ARGV[3] to the delayed set
+// KEYS[1] with the score ARGV[1]
+var enqueueAtScript = redis.NewScript(ownerLua + `
+return redis.call('ZADD', KEYS[1], ARGV[1], tag(ARGV[3], ARGV[2]))
+`)
+
+// EnqueueAtOwner adds an item of owner to the lane of priority p, which is
+// only dequeued once `at` has come and PromoteDue moved it to the lane.
+// Identical items of an owner share a single entry.
+func (q *Queue) EnqueueAtOwner(item interface{}, at time.Time, p Priority, owner string) error {
+	if err := validateOwner(owner); err != nil {
+		return fmt.Errorf("enqueue at error: %v", err)
+	}
+
+	if err := enqueueAtScript.Run(q.client, []string{q.delayedName(p)}, at.UnixMilli(), item, owner).Err(); err != nil {
+		return fmt.Errorf("enqueue at error: %v", err)
 	}
 	return nil
 }
+
+// PromoteDue moves the delayed items which are due at `now` to the end of
+// their lane, and returns how many items were moved
+func (q *Queue) PromoteDue(now time.Time) (int, error) {
+	promoted := 0
+	for _, p := range priorities {
+		keys := []string{q.delayedName(p), q.laneName(p), q.notifyName()}
+		n, err := promoteScript.Run(q.client, keys, now.UnixMilli(), promoteBatchSize).Int()
+		if err != nil {
+			return promoted, fmt.Errorf("promote error: %v", err)
+		}
+		promoted += n
+	}
+	return promoted, nil
+}
+
+// Remove removes the items for which match returns true, queued or delayed,
+// and returns how many items were removed. Items dequeued in the meantime
+// are not affected.
+func (q *Queue) Remove(match func(data []byte) bool) (int, error) {
+	removed := 0
+	for _, p := range priorities {
+		lists, err := q.ownerListNames(q.laneName(p))
+		if err != nil {
+			return removed, fmt.Errorf("remove error `SMembers`: %v", err)
+		}
+		for _, list := range lists {
+			items, err := q.client.LRange(list, 0, -1).Result()
+			if err != nil {
+				return removed, fmt.Errorf("remove error `LRange`: %v", err)
+			}
+			for _, item := range items {
+				if !match([]byte(item)) {
+					continue
+				}
+
+				n, err := q.client.LRem(list, 1, item).Result()
+				if err != nil {
+					return removed, fmt.Errorf("remove error `LRem`: %v", err)
+				}
+				removed += int(n)
+			}
+		}
+
+		delayed, err := q.client.ZRange(q.delayedName(p), 0, -1).Result()
+		if err != nil {
+			return removed, fmt.Errorf("remove error `ZRange`: %v", err)
+		}
+		for _, stored := range delayed {
+			if _, item := untag(stored); !match([]byte(item)) {
+				continue
+			}
+
+			n, err := q.client.ZRem(q.delayedName(p), stored).Result()
+			if err != nil {
+				return removed, fmt.Errorf("remove error `ZRem`: %v", err)
+			}
+			removed += int(n)
+		}
+	}
+	return removed, nil
+}
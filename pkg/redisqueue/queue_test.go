@@ -1,7 +1,10 @@
 package redisqueue_test
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis"
 	"github.com/celestiaorg/dockwiz/pkg/redisqueue"
@@ -36,3 +39,279 @@ func TestEnqueueAndDequeueWithMiniRedis(t *testing.T) {
 	err = queue.Dequeue(&item)
 	assert.ErrorIs(t, err, redisqueue.ErrQueueEmpty, "Error should be ErrQueueEmpty on dequeue from an empty queue")
 }
+
+func TestRemoveWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	for _, item := range []string{"item1", "item2", "item3"} {
+		require.NoError(t, queue.Enqueue(item), "Error enqueueing item")
+	}
+
+	removed, err := queue.Remove(func(data []byte) bool {
+		return string(data) == "item2"
+	})
+	assert.NoError(t, err, "Error removing item")
+	assert.Equal(t, 1, removed, "One item should be removed")
+
+	var item string
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	assert.Equal(t, "item1", item, "Dequeued item should match")
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	assert.Equal(t, "item3", item, "Removed item should be skipped")
+}
+
+func TestEnqueueAtWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	now := time.Now()
+	require.NoError(t, queue.EnqueueAt("later", now.Add(time.Minute)), "Error enqueueing item")
+	require.NoError(t, queue.EnqueueAt("soon", now.Add(time.Second)), "Error enqueueing item")
+	require.NoError(t, queue.EnqueueAt("removed", now), "Error enqueueing item")
+
+	var item string
+	assert.ErrorIs(t, queue.Dequeue(&item), redisqueue.ErrQueueEmpty, "Delayed items should not be dequeued")
+
+	removed, err := queue.Remove(func(data []byte) bool {
+		return string(data) == "removed"
+	})
+	require.NoError(t, err, "Error removing item")
+	assert.Equal(t, 1, removed, "Delayed items should be removed")
+
+	n, err := queue.PromoteDue(now.Add(2 * time.Second))
+	require.NoError(t, err, "Error promoting items")
+	assert.Equal(t, 1, n, "Only the due item should be promoted")
+
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	assert.Equal(t, "soon", item, "Promoted item should be dequeued")
+	assert.ErrorIs(t, queue.Dequeue(&item), redisqueue.ErrQueueEmpty, "Items not due should stay delayed")
+
+	n, err = queue.PromoteDue(now.Add(time.Minute))
+	require.NoError(t, err, "Error promoting items")
+	assert.Equal(t, 1, n)
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	assert.Equal(t, "later", item)
+}
+
+func TestReserveWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	for _, item := range []string{"item1", "item2", "item3", "item4"} {
+		require.NoError(t, queue.Enqueue(item), "Error enqueueing item")
+	}
+
+	var item string
+	acked, err := queue.Reserve("worker1", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "item1", item, "Reserved item should match")
+	require.NoError(t, acked.Ack(), "Error acknowledging item")
+
+	crashed, err := queue.Reserve("worker1", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "item2", item)
+	alive, err := queue.Reserve("worker2", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "item3", item)
+
+	n, err := queue.Reap(time.Now(), func([]byte) error { return nil })
+	require.NoError(t, err, "Error reaping items")
+	assert.Zero(t, n, "Items whose lease did not expire should not be reaped")
+
+	var requeued []string
+	consumers := map[string]string{"item2": "worker1", "item3": "worker2"}
+	n, err = queue.Reap(time.Now().Add(2*time.Minute), func(data []byte) error {
+		requeued = append(requeued, string(data))
+		assert.False(t, mr.Exists("test_queue:processing:"+consumers[string(data)]), "Items should be requeued before the callback")
+		return errors.New("recording failed")
+	})
+	assert.Error(t, err, "Errors of the callback should be returned")
+	assert.Equal(t, 2, n, "Items whose lease expired should be reaped")
+	assert.ElementsMatch(t, []string{"item2", "item3"}, requeued)
+	assert.ErrorIs(t, crashed.Extend(time.Minute), redisqueue.ErrLeaseLost, "Reaped leases should be lost")
+	assert.ErrorIs(t, alive.Extend(time.Minute), redisqueue.ErrLeaseLost)
+
+	extended, err := queue.Reserve("worker2", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Contains(t, []string{"item2", "item3"}, item, "Reaped items should be at the head of the queue")
+	require.NoError(t, extended.Extend(3*time.Minute), "Error extending lease")
+	n, err = queue.Reap(time.Now().Add(2*time.Minute), func([]byte) error { return nil })
+	require.NoError(t, err, "Error reaping items")
+	assert.Zero(t, n, "Extended leases should not be reaped")
+
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+	assert.Equal(t, "item4", item, "Reaped items should be dequeued before the others")
+}
+
+func TestDequeueContextWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, queue.Enqueue("item1"), "Error enqueueing item")
+	}()
+
+	start := time.Now()
+	var item string
+	require.NoError(t, queue.DequeueContext(context.Background(), &item), "Error dequeuing item")
+	assert.Equal(t, "item1", item, "Dequeued item should match")
+	assert.Less(t, time.Since(start), time.Second, "Waiting consumers should get new items right away")
+
+	require.NoError(t, queue.Enqueue("item2"), "Error enqueueing item")
+	lease, err := queue.ReserveContext(context.Background(), "worker1", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "item2", item, "Reserved item should match")
+	require.NoError(t, lease.Ack(), "Error acknowledging item")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.ErrorIs(t, queue.DequeueContext(ctx, &item), context.DeadlineExceeded, "Waiting should stop with the context")
+	assert.Less(t, time.Since(start), time.Second, "Waiting should stop right away")
+}
+
+func TestPriorityWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	require.NoError(t, queue.EnqueuePriority("low1", redisqueue.PriorityLow), "Error enqueueing item")
+	require.NoError(t, queue.Enqueue("normal1"), "Error enqueueing item")
+	require.NoError(t, queue.EnqueuePriority("high1", redisqueue.PriorityHigh), "Error enqueueing item")
+	require.NoError(t, queue.EnqueueAtPriority("high2", time.Now(), redisqueue.PriorityHigh), "Error enqueueing item")
+	_, err = queue.PromoteDue(time.Now().Add(time.Second))
+	require.NoError(t, err, "Error promoting items")
+
+	var item string
+	var got []string
+	for i := 0; i < 4; i++ {
+		require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+		got = append(got, item)
+	}
+	assert.Equal(t, []string{"high1", "high2", "normal1", "low1"}, got, "Higher lanes should be dequeued first")
+
+	// With a continuous stream of high priority items, the lower lanes are
+	// still served
+	queue.SetStarvationLimit(2)
+	require.NoError(t, queue.EnqueuePriority("low", redisqueue.PriorityLow), "Error enqueueing item")
+	require.NoError(t, queue.Enqueue("normal"), "Error enqueueing item")
+	got = nil
+	for i := 0; i < 6; i++ {
+		require.NoError(t, queue.EnqueuePriority("high", redisqueue.PriorityHigh), "Error enqueueing item")
+		_, err := queue.Reserve("worker1", time.Minute, &item)
+		require.NoError(t, err, "Error reserving item")
+		got = append(got, item)
+	}
+	assert.Equal(t, []string{"high", "high", "low", "normal", "high", "high"}, got, "Starved lanes should be served")
+}
+
+func TestOwnersWithMiniRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err, "Error starting miniredis server")
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		DB:   0,
+	})
+
+	queue := redisqueue.NewQueue(rdb, "test_queue")
+	require.NoError(t, rdb.RPush("test_queue", "legacy").Err(), "Error pushing item")
+	for _, item := range []string{"a1", "a2", "a3", "a4"} {
+		require.NoError(t, queue.EnqueueOwner(item, redisqueue.PriorityNormal, "a"), "Error enqueueing item")
+	}
+	require.NoError(t, queue.EnqueueOwner("b1", redisqueue.PriorityNormal, "b"), "Error enqueueing item")
+	require.NoError(t, queue.EnqueueAtOwner("b2", time.Now(), redisqueue.PriorityNormal, "b"), "Error enqueueing item")
+	require.NoError(t, queue.EnqueueAtOwner("b3", time.Now(), redisqueue.PriorityNormal, "b"), "Error enqueueing item")
+	_, err = queue.PromoteDue(time.Now().Add(time.Second))
+	require.NoError(t, err, "Error promoting items")
+	require.Error(t, queue.EnqueueOwner("c1", redisqueue.PriorityNormal, "c\x00"), "Owners with a NUL byte should be rejected")
+
+	n, err := queue.Remove(func(data []byte) bool { return string(data) == "a4" || string(data) == "b3" })
+	require.NoError(t, err, "Error removing items")
+	assert.Equal(t, 2, n, "Items of owners should be removed")
+
+	var item string
+	var got []string
+	for i := 0; i < 6; i++ {
+		require.NoError(t, queue.Dequeue(&item), "Error dequeuing item")
+		got = append(got, item)
+	}
+	assert.Equal(t, []string{"a1", "b1", "a2", "b2", "a3", "legacy"}, got, "Owners should be served round-robin")
+	assert.ErrorIs(t, queue.Dequeue(&item), redisqueue.ErrQueueEmpty)
+
+	// Owners with as many reserved items as their limit are skipped
+	queue.SetOwnerLimits(1, map[string]int{"b": 2})
+	for _, item := range []string{"a1", "a2"} {
+		require.NoError(t, queue.EnqueueOwner(item, redisqueue.PriorityNormal, "a"), "Error enqueueing item")
+	}
+	for _, item := range []string{"b1", "b2", "b3"} {
+		require.NoError(t, queue.EnqueueOwner(item, redisqueue.PriorityNormal, "b"), "Error enqueueing item")
+	}
+
+	done, err := queue.Reserve("worker1", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "a1", item)
+	_, err = queue.Reserve("worker2", time.Second, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "b1", item)
+	_, err = queue.Reserve("worker3", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "b2", item, "Owners at their limit should be skipped")
+	_, err = queue.Reserve("worker4", time.Minute, &item)
+	assert.ErrorIs(t, err, redisqueue.ErrQueueEmpty, "Owners at their limit should be skipped")
+
+	require.NoError(t, done.Ack(), "Error acknowledging item")
+	_, err = queue.Reserve("worker4", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "a2", item, "Acknowledged items should not count toward the limit")
+
+	var requeued []string
+	n, err = queue.Reap(time.Now().Add(30*time.Second), func(data []byte) error {
+		requeued = append(requeued, string(data))
+		return nil
+	})
+	require.NoError(t, err, "Error reaping items")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1"}, requeued, "Reaped items should be untagged")
+	_, err = queue.Reserve("worker5", time.Minute, &item)
+	require.NoError(t, err, "Error reserving item")
+	assert.Equal(t, "b1", item, "Reaped items should not count toward the limit")
+}
@@ -0,0 +1,215 @@
+package redisqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrLeaseLost is returned when extending a lease which expired and whose
+// item was returned to the queue
+var ErrLeaseLost = errors.New("lease lost")
+
+// reapBatchSize bounds the consumers reaped by a single Reap call
+const reapBatchSize = 100
+
+// reserveScript moves the next item of the lanes KEYS[4..] (see popLua) to
+// the processing list KEYS[1] of the consumer, whose lease in KEYS[2] and
+// running item of its owner are extended to the deadline
+var reserveScript = redis.NewScript(popLua + `
+local stored = pop(4)
+if not stored then
+	return false
+end
+redis.call('RPUSH', KEYS[1], stored)
+redis.call('ZADD', KEYS[2], deadline, consumer)
+redis.call('ZADD', running .. untag(stored), deadline, consumer)
+return stored
+`)
+
+// extendScript extends the lease of consumer ARGV[1] in KEYS[1], and its
+// running item in KEYS[2], to ARGV[2], unless it was reaped
+var extendScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// ackScript removes the item ARGV[2] from the processing list KEYS[1], and
+// the lease of consumer ARGV[1] in KEYS[2] once it has no item left. Its
+// running item in KEYS[3] is removed, and KEYS[4], if given, is notified as
+// an item of the owner may now be reserved.
+var ackScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[2])
+if redis.call('LLEN', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+if KEYS[4] then
+	redis.call('RPUSH', KEYS[4], 1)
+end
+return 1
+`)
+
+// reapScript moves the items of the processing list KEYS[1] back to the
+// head of the lists of their owner in the lane KEYS[2], in order, if the
+// lease of consumer ARGV[1] in KEYS[3] is still expired at ARGV[2], and
+// notifies KEYS[4] of each of them. The running items of the consumer,
+// prefixed by ARGV[3], are removed. It returns the moved items, as stored.
+var reapScript = redis.NewScript(ownerLua + `
+local deadline = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if deadline and tonumber(deadline) > tonumber(ARGV[2]) then
+	return {}
+end
+local moved = {}
+while true do
+	local stored = redis.call('RPOP', KEYS[1])
+	if not stored then
+		break
+	end
+	push(KEYS[2], stored, true)
+	redis.call('ZREM', ARGV[3] .. untag(stored), ARGV[1])
+	redis.call('RPUSH', KEYS[4], 1)
+	table.insert(moved, stored)
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+return moved
+`)
+
+// Lease is an item reserved by a consumer. The item goes back to the queue
+// if the lease expires before the item is acknowledged.
+type Lease struct {
+	q        *Queue
+	consumer string
+	owner    string
+	item     string // as stored, tagged with its owner
+}
+
+// processingName is the list of the items reserved by a consumer
+func (q *Queue) processingName(consumer string) string {
+	return q.name + ":processing:" + consumer
+}
+
+// leasesName is the sorted set of the consumers with reserved items, scored
+// by the unix time in milliseconds their lease expires at
+func (q *Queue) leasesName() string {
+	return q.name + ":leases"
+}
+
+// Reserve moves the next item of the queue (see Dequeue) to the processing
+// list of consumer, where it stays until acknowledged. Unlike Dequeue, the item is
+// returned to the queue by Reap if the consumer does not extend its lease
+// within visibility, e.g. because it crashed. The items of an owner at its
+// limit (see SetOwnerLimits) are skipped, and a consumer counts once toward
+// the limit of an owner however many of its items it reserved.
+func (q *Queue) Reserve(consumer string, visibility time.Duration, data interface{}) (*Lease, error) {
+	deadline := time.Now().Add(visibility).UnixMilli()
+	keys := append([]string{q.processingName(consumer), q.leasesName(), q.skipsName()}, q.laneNames()...)
+	stored, err := reserveScript.Run(q.client, keys, q.popArgs(time.Now().UnixMilli(), consumer, deadline)...).String()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrQueueEmpty
+		}
+		return nil, fmt.Errorf("reserve error: %v", err)
+	}
+
+	owner, item := untag(stored)
+	lease := &Lease{q: q, consumer: consumer, owner: owner, item: stored}
+	if err := redis.NewStringResult(item, nil).Scan(data); err != nil {
+		// The item can never be processed, so it is not returned to the queue
+		if ackErr := lease.Ack(); ackErr != nil {
+			return nil, fmt.Errorf("error converting result: %v (ack error: %v)", err, ackErr)
+		}
+		return nil, fmt.Errorf("error converting result: %v", err)
+	}
+	return lease, nil
+}
+
+// ReserveContext is Reserve, but it waits for an item until ctx is done, in
+// which case it returns the error of ctx
+func (q *Queue) ReserveContext(ctx context.Context, consumer string, visibility time.Duration, data interface{}) (*Lease, error) {
+	for {
+		lease, err := q.Reserve(consumer, visibility, data)
+		if err != ErrQueueEmpty {
+			return lease, err
+		}
+		if err := q.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Extend extends the lease of the consumer by visibility from now. It
+// returns ErrLeaseLost if the lease expired and its item was reaped.
+func (l *Lease) Extend(visibility time.Duration) error {
+	deadline := time.Now().Add(visibility).UnixMilli()
+	keys := []string{l.q.leasesName(), l.q.runningPrefix() + l.owner}
+	ok, err := extendScript.Run(l.q.client, keys, l.consumer, deadline).Int()
+	if err != nil {
+		return fmt.Errorf("extend error: %v", err)
+	}
+	if ok == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Ack acknowledges the item once processed, so it is never returned to the
+// queue
+func (l *Lease) Ack() error {
+	keys := []string{l.q.processingName(l.consumer), l.q.leasesName(), l.q.runningPrefix() + l.owner}
+	if l.q.hasOwnerLimits() {
+		keys = append(keys, l.q.notifyName())
+	}
+	if err := ackScript.Run(l.q.client, keys, l.consumer, l.item).Err(); err != nil {
+		return fmt.Errorf("ack error: %v", err)
+	}
+	return nil
+}
+
+// Reap returns the items of the consumers whose lease expired at now to the
+// head of the lists of their owner in the high priority lane, as they were
+// dequeued before the items queued since, and returns how many items were
+// returned. requeue is called with each item once it is back in the queue,
+// e.g. to record the requeue, so a consumer whose lease was extended
+// meanwhile keeps its item; its errors are returned once every item is
+// requeued.
+func (q *Queue) Reap(now time.Time, requeue func(data []byte) error) (int, error) {
+	consumers, err := q.client.ZRangeByScore(q.leasesName(), redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprint(now.UnixMilli()),
+		Count: reapBatchSize,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("reap error `ZRangeByScore`: %v", err)
+	}
+
+	var (
+		reaped int
+		errs   []error
+	)
+	for _, consumer := range consumers {
+		keys := []string{q.processingName(consumer), q.laneName(PriorityHigh), q.leasesName(), q.notifyName()}
+		res, err := reapScript.Run(q.client, keys, consumer, now.UnixMilli(), q.runningPrefix()).Result()
+		if err != nil {
+			return reaped, fmt.Errorf("reap error: %v", err)
+		}
+		moved, _ := res.([]interface{})
+		reaped += len(moved)
+
+		for _, stored := range moved {
+			s, _ := stored.(string)
+			_, item := untag(s)
+			if err := requeue([]byte(item)); err != nil {
+				errs = append(errs, fmt.Errorf("reap error: %w", err))
+			}
+		}
+	}
+	return reaped, errors.Join(errs...)
+}